@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup writes a consistent copy of the diary to path with the SQLite backup
+// API, so that it can be taken while the diary is in use. The copy is written
+// to a new temporary file next to path and moved there only when complete (and, if check is set,
+// found intact). If keep is positive, the previous backups are rotated to
+// path.1, path.2, ... path.keep; otherwise path is replaced. Encrypted diaries
+// are copied as they are, the copy is unlocked by the same passphrase.
+// Neither path nor the rotated backups may be the diary, under any name.
+func (d *Diary) Backup(path string, keep int, check bool) (err error) {
+	var tmp string
+
+	for n := 0; n <= keep && err == nil; n++ {
+		if sameFile(numberedBackup(path, n), d.path) {
+			err = errors.New("the backup would overwrite the diary")
+		}
+	}
+
+	// a new file, never an existing one: it is removed on errors
+	if err == nil {
+		var fp *os.File
+
+		fp, err = os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+		if err == nil {
+			tmp = fp.Name()
+			err = fp.Close()
+		}
+	}
+
+	if err != nil {
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+
+		return
+	}
+
+	err = d.backupTo(tmp)
+
+	if err == nil {
+		err = os.Chmod(tmp, d.Perm)
+	}
+
+	if err == nil && check {
+		err = checkBackup(tmp)
+	}
+
+	if err == nil && keep > 0 {
+		err = rotateBackups(path, keep)
+	}
+
+	// path is replaced at once: it is never missing, nor incomplete
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return
+}
+
+// sameFile tells whether a and b exist and are the same file, even if
+// through symbolic or hard links.
+func sameFile(a string, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+func (d *Diary) backupTo(path string) (err error) {
+	var ctx = context.Background()
+
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return
+	}
+
+	defer dst.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return
+	}
+
+	defer dstConn.Close()
+
+	srcConn, err := d.pool.Conn(ctx)
+	if err != nil {
+		return
+	}
+
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriver any) error {
+		return srcConn.Raw(func(srcDriver any) (err error) {
+			dstC, okD := dstDriver.(*sqlite3.SQLiteConn)
+			srcC, okS := srcDriver.(*sqlite3.SQLiteConn)
+			if !okD || !okS {
+				return errors.New("backup: not a SQLite connection")
+			}
+
+			b, err := dstC.Backup("main", srcC, "main")
+			if err != nil {
+				return
+			}
+
+			// every page in a single step: the source is read under one lock,
+			// and the copy is a snapshot even if another process writes
+			_, err = b.Step(-1)
+			if err == nil {
+				d.logf("Backup: %d pages\n", b.PageCount())
+			}
+
+			if errF := b.Finish(); err == nil {
+				err = errF
+			}
+
+			return
+		})
+	})
+}
+
+// integrityCheck runs PRAGMA integrity_check on db: problems is empty if the
+// database is intact.
+func integrityCheck(db dbtx) (problems []string, err error) {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var line string
+
+		err = rows.Scan(&line)
+		if err == nil && line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+func checkBackup(path string) (err error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return
+	}
+
+	defer db.Close()
+
+	problems, err := integrityCheck(db)
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("backup failed the integrity check: %s", strings.Join(problems, "; "))
+	}
+
+	return
+}
+
+func numberedBackup(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups moves path.(keep-1) to path.keep, ... path.1 to path.2: the
+// oldest backup is discarded. path is linked as path.1, not moved, so that it
+// stays in place until the new backup replaces it.
+func rotateBackups(path string, keep int) (err error) {
+	for n := keep - 1; n >= 1 && err == nil; n-- {
+		err = os.Rename(numberedBackup(path, n), numberedBackup(path, n+1))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+
+	if err == nil {
+		err = os.Remove(numberedBackup(path, 1))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+
+	if err == nil {
+		err = os.Link(path, numberedBackup(path, 1))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+
+	return
+}
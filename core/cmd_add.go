@@ -4,16 +4,16 @@ package diary
 
 import (
 	"bufio"
-	"database/sql"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-func cmdAdd(db *sql.DB) (err error) {
+func cmdAdd(d *Diary) (err error) {
 	var note = args.Note
 
 	if note == "" {
-		note, err = editor()
+		note, err = editor("")
 		if err != nil {
 			return
 		}
@@ -25,7 +25,7 @@ func cmdAdd(db *sql.DB) (err error) {
 		Note: note,
 	}
 
-	err = entry.Insert(db)
+	err = d.AddEntry(&entry, args.Tags)
 	if err != nil {
 		return
 	}
@@ -38,15 +38,14 @@ func cmdAdd(db *sql.DB) (err error) {
 	logger.info.Printf("Inserted, with id #%d", entry.Id)
 
 	if !args.NoAttach {
-		askForAttachments(db, entry.Id)
+		askForAttachments(d, entry.Id)
 	}
 
 	return
 }
 
-func askForAttachments(db *sql.DB, id int64) {
+func askForAttachments(d *Diary, id int64) {
 	var k = bufio.NewScanner(os.Stdin)
-	var buf = make([]byte, 0)
 
 	for {
 		var errF error
@@ -87,31 +86,24 @@ func askForAttachments(db *sql.DB, id int64) {
 			continue
 		}
 
-		if stat.Size() > getMaxBlobSize() {
-			logger.err.Printf("file too big: max %s\n", sizeNorm(getMaxBlobSize()))
-			continue
+		var attachment = Attachment{
+			Name:     stat.Name(),
+			EntryId:  id,
+			Modified: stat.ModTime(),
 		}
 
-		clear(buf)
-		buf = make([]byte, stat.Size())
-
-		_, errF = fp.Read(buf)
+		attachment.Path, errF = filepath.Abs(attachmentPath)
 		if errF != nil {
-			logger.err.Printf("could not open file: %v\n", errF)
-			continue
+			logger.warn.Printf("could not resolve path: %v\n", errF)
 		}
 
-		var attachment = Attachment{
-			Name:    stat.Name(),
-			EntryId: id,
-			Content: buf,
-		}
+		errF = attachment.InsertFrom(d, fp)
+		fp.Close()
 
-		errF = attachment.Insert(db)
 		if errF != nil {
 			logger.err.Printf("could not store file: %v\n", errF)
 			continue
 		}
-		logger.info.Printf("Attached %s (%s)\n", stat.Name(), sizeNorm(len(buf)))
+		logger.info.Printf("Attached %s (%s)\n", stat.Name(), sizeNorm(stat.Size()))
 	}
 }
@@ -3,25 +3,26 @@
 package diary
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 )
 
-func cmdAddAttach(db *sql.DB) (err error) {
+func cmdAddAttach(d *Diary) (err error) {
 	if args.Id <= 0 {
 		err = errors.New("you must specify an id")
 	}
 
 	if err == nil {
-		_, err = RetrieveEntryByID(db, args.Id)
+		var entry Entry
 
-		if err == NOT_FOUND {
+		entry, err = RetrieveEntryByID(d, args.Id)
+
+		if err == NOT_FOUND || err == nil && entry.Deleted {
 			err = fmt.Errorf("entry #%d not found", args.Id)
 		}
 
 		if err == nil {
-			askForAttachments(db, args.Id)
+			askForAttachments(d, args.Id)
 		}
 	}
 
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+func cmdBackup(d *Diary) (err error) {
+	var path string
+
+	switch args.OutputFileStr {
+	case "":
+		return errors.New("no file provided, use -output")
+	case "-":
+		return errors.New("backups cannot be written on stdout")
+	}
+
+	// the diary itself, even under another name, is refused by Backup
+	path, err = filepath.Abs(args.OutputFileStr)
+	if err == nil {
+		err = d.Backup(path, args.Keep, args.Check)
+	}
+
+	if err == nil {
+		logger.info.Printf("Backup written to %s", path)
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// cmdCompact recompresses stored contents with the codec given by -codec.
+func cmdCompact(d *Diary) (err error) {
+	var before, after int64
+
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+
+	err = compactChunks(d, tx, &before, &after)
+
+	if err != nil || args.DryRun {
+		tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Stored before: %s\n", sizeNorm(before))
+	fmt.Printf("Stored after:  %s\n", sizeNorm(after))
+
+	if !args.DryRun {
+		logger.info.Println("Vacuuming")
+		_, err = d.db.Exec("VACUUM")
+	}
+
+	return
+}
+
+// compactChunks recompresses the chunks of blobs one at a time.
+func compactChunks(d *Diary, tx *sql.Tx, before *int64, after *int64) (err error) {
+	ids, err := querySingleInt64Array(tx, "select rowid from blob_chunks order by rowid")
+
+	for _, id := range ids {
+		var data, plain []byte
+		var codec string
+
+		if err != nil {
+			break
+		}
+
+		err = tx.QueryRow("select codec, data from blob_chunks where rowid = ?", id).Scan(&codec, &data)
+		if err == nil {
+			plain, err = d.unpackContent(data, codec)
+		}
+
+		if err == nil {
+			*before += int64(len(data))
+			data, codec, err = d.packContent(plain)
+		}
+
+		if err == nil {
+			*after += int64(len(data))
+			_, err = tx.Exec("update blob_chunks set codec = ?, data = ? where rowid = ?", codec, data, id)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("blob chunk %d: %s", id, err.Error())
+		}
+	}
+
+	return
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// cmdDedup moves contents still stored in attachments to blobs. Opening the
+// diary already does it (see convertInline): what is left is to compact it.
+func cmdDedup(d *Diary) (err error) {
+	ids, err := querySingleInt64Array(d.db, "select id from attachments where content is not null order by id")
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Attachments to convert: %d\n", len(ids))
+	if args.DryRun {
+		return
+	}
+
+	converted, saved, err := convertInline(d)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Converted:              %d\n", converted)
+	fmt.Printf("Duplicates saved:       %s\n", sizeNorm(saved))
+
+	logger.info.Println("Vacuuming")
+	_, err = d.db.Exec("VACUUM")
+
+	return
+}
+
+// convertInline converts contents stored by older versions: stored as a
+// whole in blobs, they are split into chunks (see CHUNK_SIZE); stored in
+// attachments, they are moved to blobs, which keep their plain size: the
+// stored length of a content is not its size once compressed or sealed.
+// Locked diaries are left as they are.
+func convertInline(d *Diary) (converted int64, saved int64, err error) {
+	if d.aead == nil {
+		if encrypted, errE := d.IsEncrypted(); errE != nil || encrypted {
+			return 0, 0, errE
+		}
+	}
+
+	hashes, err := querySingleStringArray(d.db, "select hash from blobs where content is not null order by hash")
+	if err != nil {
+		return
+	}
+
+	ids, err := querySingleInt64Array(d.db, "select id from attachments where content is not null order by id")
+	if err != nil || len(hashes) == 0 && len(ids) == 0 {
+		return
+	}
+
+	d.logf("Converting %d content(s) stored by an older version\n", len(hashes)+len(ids))
+
+	err = d.inTx(func(dx *Diary) (err error) {
+		for _, hash := range hashes {
+			var content []byte
+			var codec string
+
+			err = dx.db.QueryRow("select codec, content from blobs where hash = ?", hash).Scan(&codec, &content)
+			if err == nil {
+				content, err = dx.unpackContent(content, codec)
+			}
+
+			if err == nil {
+				_, err = writeChunks(dx, dx.db, hash, bytes.NewReader(content))
+			}
+
+			if err == nil {
+				_, err = dx.db.Exec("update blobs set size = ?, codec = '', content = NULL where hash = ?", len(content), hash)
+			}
+
+			if err != nil {
+				return fmt.Errorf("blob %s: %s", hash, err.Error())
+			}
+		}
+
+		for _, id := range ids {
+			var content []byte
+			var codec string
+			var hash string
+			var inserted bool
+			var mime, sum string
+
+			err = dx.db.QueryRow("select codec, content from attachments where id = ?", id).Scan(&codec, &content)
+			if err == nil {
+				content, err = dx.unpackContent(content, codec)
+			}
+
+			if err == nil {
+				hash, inserted, err = storeBlob(dx, dx.db, content)
+				if !inserted {
+					saved += int64(len(content))
+				}
+			}
+
+			if err == nil {
+				mime, sum, _, err = sniffContent(dx, bytes.NewReader(content))
+			}
+
+			if err == nil {
+				_, err = dx.db.Exec("update attachments set hash = ?, codec = '', content = NULL, mime = coalesce(mime, ?), sha256 = coalesce(sha256, ?) where id = ?", hash, mime, dx.sealString(sum), id)
+			}
+
+			if err != nil {
+				return fmt.Errorf("attachment #%d: %s", id, err.Error())
+			}
+
+			converted++
+		}
+
+		return
+	})
+
+	if err != nil {
+		converted, saved = 0, 0
+	}
+
+	return
+}
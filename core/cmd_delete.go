@@ -3,17 +3,71 @@
 package diary
 
 import (
-	"database/sql"
 	"errors"
+	"fmt"
+	"os"
 )
 
-func cmdDelete(db *sql.DB) (err error) {
+func cmdDelete(_ *Diary) error {
+	return errors.New("delete has been replaced by delete-entry and delete-attachment")
+}
+
+func cmdDeleteEntry(d *Diary) (err error) {
+	if args.Id < 0 {
+		err = errors.New("invalid id")
+		return
+	}
+
+	entry, err := RetrieveEntryByID(d, args.Id)
+	if err == NOT_FOUND || err == nil && entry.Deleted {
+		err = fmt.Errorf("entry #%d not found", args.Id)
+	}
+	if err != nil {
+		return
+	}
+
+	if !args.Force {
+		fmt.Println("The following entry, along with its attachments, will be deleted:")
+		fmt.Println()
+		_, err = entry.FPrintResume(d, os.Stdout)
+		fmt.Println()
+
+		if err != nil || !confirm("Delete?") {
+			return
+		}
+	}
+
+	aff, err := DeleteEntry(d, args.Id)
+	if err == nil {
+		logger.info.Printf("%d row(s) deleted\n", aff)
+	}
+
+	return
+}
+
+func cmdDeleteAttachment(d *Diary) (err error) {
 	if args.Id < 0 {
 		err = errors.New("invalid id")
 		return
 	}
 
-	aff, err := DeleteAttachment(db, args.Id)
+	attachment, err := RetrieveAttachmentByIDNC(d, args.Id)
+	if err == NOT_FOUND || err == nil && attachment.Deleted {
+		err = fmt.Errorf("attachment #%d not found", args.Id)
+	}
+	if err != nil {
+		return
+	}
+
+	if !args.Force {
+		fmt.Printf("The following attachment will be deleted:\n\n[%d] %s (entry #%d)\n\n", attachment.Id, attachment.Name, attachment.EntryId)
+
+		if !confirm("Delete?") {
+			return
+		}
+	}
+
+	aff, err := DeleteAttachment(d, args.Id)
 	if err == nil {
 		logger.info.Printf("%d row(s) deleted\n", aff)
 	}
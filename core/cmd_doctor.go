@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"fmt"
+)
+
+func cmdDoctor(d *Diary) (err error) {
+	var fixed int
+
+	findings, err := d.Doctor(args.Fix)
+
+	for _, fx := range findings {
+		fmt.Printf("[%s] %s", fx.Check, fx.Note)
+
+		if fx.Fixed {
+			fmt.Print(" (fixed)")
+			fixed++
+		}
+
+		fmt.Println()
+	}
+
+	if err != nil {
+		return
+	}
+
+	if len(findings) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+
+	fmt.Printf("%d problem(s) found, %d fixed\n", len(findings), fixed)
+
+	return
+}
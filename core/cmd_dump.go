@@ -3,93 +3,128 @@
 package diary
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
-
-	_ "embed"
 )
 
-//go:embed res/head_dump_index.html
-var headDumpIndex string
+// Pages link each other with relative paths: the same hierarchy is written
+// by dump and served by serve.
 
-func cmdDump(db *sql.DB) (err error) {
-	years, err := querySingleInt64Array(db, "SELECT DISTINCT strftime('%Y', datetime(init, 'unixepoch')) from entries where deleted = 0")
-	if err != nil {
-		return
+// entryDays returns the midnights, in d.Zone, of the days with entries in
+// [from, to), or of all of them if from is zero.
+func entryDays(d *Diary, tags []string, from time.Time, to time.Time) (days []time.Time, err error) {
+	var query = "select init from entries where deleted = 0"
+	var params []any
+
+	if !from.IsZero() {
+		query += " and init >= ? and init < ?"
+		params = append(params, from.Unix(), to.Unix())
 	}
 
-	fp, err := os.OpenFile("index.html", os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(args.OutputPerm))
-	if err != nil {
-		return
+	tagClause, tagParams := tagFilter(tags)
+
+	inits, err := querySingleInt64Array(d.db, query+tagClause+" order by init", append(params, tagParams...)...)
+	for _, ix := range inits {
+		day := d.dayStart(time.Unix(ix, 0).In(d.Zone))
+
+		if len(days) == 0 || !day.Equal(days[len(days)-1]) {
+			days = append(days, day)
+		}
 	}
 
-	defer fp.Close()
-	_, err = fmt.Fprintf(fp, "<html>"+headDumpIndex+"<body><ul>", "Diary Dump")
+	return
+}
+
+func dumpIndexPage(d *Diary, tags []string) (page DumpPage, years []int64, err error) {
+	days, err := entryDays(d, tags, time.Time{}, time.Time{})
 	if err != nil {
 		return
 	}
 
+	for _, dx := range days {
+		if yx := int64(dx.Year()); len(years) == 0 || years[len(years)-1] != yx {
+			years = append(years, yx)
+		}
+	}
+
+	page.Title = "Diary Dump"
 	for _, yx := range years {
 		dir := fmt.Sprintf("%d", yx)
+		page.Links = append(page.Links, DumpLink{Href: dir + "/index.html", Text: dir})
+	}
 
-		_, err = fmt.Fprintf(fp, "<li><a href=\"%s/index.html\">%s</a>\n", dir, dir)
-
-		if err == nil {
-			if args.Force {
-				err = rmR(dir, true)
-			}
-		}
+	return
+}
 
-		if err == nil {
-			err = createDirectoryIfNE(dir)
-		}
+func dumpYearPage(d *Diary, tags []string, year int64) (page DumpPage, months []int64, err error) {
+	from := time.Date(int(year), time.January, 1, 0, 0, 0, 0, d.Zone)
 
-		if err == nil {
-			err = dumpSingleYear(db, yx, dir)
-		}
+	days, err := entryDays(d, tags, from, from.AddDate(1, 0, 0))
+	if err != nil {
+		return
+	}
 
-		if err != nil {
-			break
+	for _, dx := range days {
+		if mx := int64(dx.Month()); len(months) == 0 || months[len(months)-1] != mx {
+			months = append(months, mx)
 		}
 	}
 
-	if err == nil {
-		_, err = fmt.Fprintf(fp, "</ul></body></html>")
+	page.Title = fmt.Sprintf("%d", year)
+	for _, mx := range months {
+		var dirX = fmt.Sprintf("%d/%02d", year, mx)
+		page.Links = append(page.Links, DumpLink{Href: "../" + dirX + "/index.html", Text: dirX})
 	}
 
 	return
 }
 
-func dumpSingleYear(db *sql.DB, year int64, dir string) (err error) {
-	months, err := querySingleInt64Array(db, "SELECT DISTINCT strftime('%m', datetime(init, 'unixepoch')) from entries where deleted = 0 AND strftime('%Y', datetime(init, 'unixepoch')) = cast(? as TEXT)", year)
+func dumpMonthPage(d *Diary, tags []string, year int64, month int64) (page DumpPage, days []int64, err error) {
+	from := time.Date(int(year), time.Month(month), 1, 0, 0, 0, 0, d.Zone)
+
+	dates, err := entryDays(d, tags, from, from.AddDate(0, 1, 0))
 	if err != nil {
 		return
 	}
 
-	fp, err := os.OpenFile(dir+"/index.html", os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(args.OutputPerm))
+	for _, dx := range dates {
+		days = append(days, int64(dx.Day()))
+	}
+
+	page.Title = fmt.Sprintf("%d/%02d", year, month)
+	for _, dx := range days {
+		var dirX = fmt.Sprintf("%d/%02d/%02d", year, month, dx)
+		page.Links = append(page.Links, DumpLink{Href: "../../" + dirX + "/index.html", Text: dirX})
+	}
+
+	return
+}
+
+func cmdDump(d *Diary) (err error) {
+	return d.Dump(".", args.Tags, args.Force)
+}
+
+func dumpSingleYear(d *Diary, tags []string, year int64, dir string) (err error) {
+	page, months, err := dumpYearPage(d, tags, year)
 	if err != nil {
 		return
 	}
 
-	defer fp.Close()
-	_, err = fmt.Fprintf(fp, "<html>"+headDumpIndex+"<body><ul>", dir)
+	err = d.writeDumpPage(filepath.Join(dir, "index.html"), "dump_index", page)
 	if err != nil {
 		return
 	}
 
 	for _, mx := range months {
-		var dirX = fmt.Sprintf("%s/%02d", dir, mx)
+		var dirX = filepath.Join(dir, fmt.Sprintf("%02d", mx))
 
-		_, err = fmt.Fprintf(fp, "<li><a href=\"../%s/index.html\">%s</a>\n", dirX, dirX)
+		err = d.createDirectoryIfNE(dirX)
 
 		if err == nil {
-			err = createDirectoryIfNE(dirX)
-		}
-
-		if err == nil {
-			err = dumpSingleMonth(db, year, mx, dirX)
+			err = dumpSingleMonth(d, tags, year, mx, dirX)
 		}
 
 		if err != nil {
@@ -97,41 +132,28 @@ func dumpSingleYear(db *sql.DB, year int64, dir string) (err error) {
 		}
 	}
 
-	if err == nil {
-		_, err = fmt.Fprintf(fp, "</ul></body></html>")
-	}
-
 	return
 }
 
-func dumpSingleMonth(db *sql.DB, year int64, month int64, dir string) (err error) {
-	days, err := querySingleInt64Array(db, "SELECT DISTINCT strftime('%d', datetime(init, 'unixepoch')) from entries where deleted = 0 AND strftime('%Y', datetime(init, 'unixepoch')) = cast(? as TEXT) AND CAST(strftime('%m', datetime(init, 'unixepoch')) AS INTEGER) = ?", year, month)
-	if err != nil {
-		return
-	}
-
-	fp, err := os.OpenFile(dir+"/index.html", os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(args.OutputPerm))
+func dumpSingleMonth(d *Diary, tags []string, year int64, month int64, dir string) (err error) {
+	page, days, err := dumpMonthPage(d, tags, year, month)
 	if err != nil {
 		return
 	}
 
-	defer fp.Close()
-	_, err = fmt.Fprintf(fp, "<html>"+headDumpDay+"<body><ul>", dir)
+	err = d.writeDumpPage(filepath.Join(dir, "index.html"), "dump_month", page)
 	if err != nil {
 		return
 	}
 
 	for _, dx := range days {
-		var dirX = fmt.Sprintf("%s/%02d", dir, dx)
+		var dirX = filepath.Join(dir, fmt.Sprintf("%02d", dx))
 
-		_, err = fmt.Fprintf(fp, "<li><a href=\"../../%s/index.html\">%s</a>\n", dirX, dirX)
+		err = d.createDirectoryIfNE(dirX)
 
 		if err == nil {
-			err = createDirectoryIfNE(dirX)
-		}
-
-		if err == nil {
-			err = dumpSingleDay(db, year, month, dx, dirX)
+			date := time.Date(int(year), time.Month(month), int(dx), 0, 0, 0, 0, d.Zone)
+			err = d.dumpDayFiles(dirX, date, tags)
 		}
 
 		if err != nil {
@@ -139,28 +161,67 @@ func dumpSingleMonth(db *sql.DB, year int64, month int64, dir string) (err error
 		}
 	}
 
-	if err == nil {
-		_, err = fmt.Fprintf(fp, "</ul></body></html>")
+	return
+}
+
+func (d *Diary) rmR(path string, onlyChildren bool) (err error) {
+	var ddee []os.DirEntry
+	var stat os.FileInfo
+
+	stat, err = os.Stat(path)
+
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+
+	if err == nil && stat.IsDir() {
+
+		ddee, err = os.ReadDir(path)
+		if err == nil {
+			for _, dex := range ddee {
+				err = d.rmR(filepath.Join(path, dex.Name()), false)
+
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if err == nil && !onlyChildren {
+		if stat.IsDir() {
+			d.logf("Deleting directory \"%s\"", path)
+		} else {
+			d.logf("Deleting regular file \"%s\"", path)
+		}
+
+		err = os.Remove(path)
 	}
 
 	return
 }
 
-func dumpSingleDay(db *sql.DB, year int64, month int64, day int64, dir string) (err error) {
-	args.DateInit = time.Date(int(year), time.Month(month), int(day), 0, 0, 0, 0, time.Now().Location())
+func (d *Diary) createDirectoryIfNE(dir string) error {
+	var ddee []os.DirEntry
 
-	tmpWD, err := os.Getwd()
+	stat, err := os.Stat(dir)
 	if err == nil {
+		if !stat.IsDir() {
+			err = errors.New(dir + " is not a directory")
+		}
 
-		err = os.Chdir(dir)
 		if err == nil {
+			ddee, err = os.ReadDir(dir)
 
-			err = cmdDumpDay(db)
-			if err == nil {
-
-				err = os.Chdir(tmpWD)
+			if len(ddee) > 0 {
+				err = errors.New(dir + " not empty")
 			}
 		}
+	} else if os.IsNotExist(err) {
+		err = os.Mkdir(dir, d.Perm|0100)
+		d.logf("created directory %s", dir)
 	}
-	return
+
+	return err
 }
@@ -3,53 +3,111 @@
 package diary
 
 import (
-	"database/sql"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
-
-	_ "embed"
 )
 
-//go:embed res/head_dump_day.html
-var headDumpDay string
-
-func cmdDumpDay(db *sql.DB) (err error) {
-	dateI, _ := time.ParseInLocation(time.DateOnly, args.DateInit.Format(time.DateOnly), time.Now().Location())
-	dateE := dateI.Add(24 * time.Hour)
-	var fp *os.File
+func cmdDumpDay(d *Diary) (err error) {
+	return d.dumpDayFiles(".", args.DateInit, args.Tags)
+}
 
-	fp, err = os.OpenFile("index.html", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(args.OutputPerm))
+// dumpDayFiles writes the dump-day page of the day of date, and its
+// attachments, in dir.
+func (d *Diary) dumpDayFiles(dir string, date time.Time, tags []string) (err error) {
+	page, err := dumpDayPage(d, date, tags, FileDumper(dir))
 	if err != nil {
 		return
 	}
-	defer fp.Close()
 
-	fmt.Fprintln(fp, "<html>")
-	fmt.Fprintf(fp, headDumpDay, dateI.Format(time.DateOnly))
+	return d.writeDumpPage(filepath.Join(dir, "index.html"), "dump_day", page)
+}
 
-	rows, err := db.Query(QUERY_ENTRY_ALL+" where init >= ? and init < ? and deleted = 0 order by init", dateI.Unix(), dateE.Unix())
-	if err != nil {
-		return
+// dumpDayPage prepares the dump-day page of the calendar day of date, in
+// d.Zone.
+func dumpDayPage(d *Diary, date time.Time, tags []string, dumper AttachmentDumper) (page DumpPage, err error) {
+	dateI := d.dayStart(date)
+	dateE := dateI.AddDate(0, 0, 1)
+
+	page.Title = dateI.Format(time.DateOnly)
+
+	entries, err := d.Entries(dateI, dateE, tags)
+
+	for _, entry := range entries {
+		var de DumpEntry
+
+		de, err = entry.DumpDay(d, dumper)
+		if err != nil {
+			return
+		}
+
+		page.Entries = append(page.Entries, de)
 	}
 
-	defer rows.Close()
-	for rows.Next() && err == nil {
-		var entry Entry
+	return
+}
+
+// FileDumper writes attachments, and their thumbnails, in dir: pages link
+// them by name.
+func FileDumper(dir string) AttachmentDumper {
+	return func(d *Diary, a Attachment, da *DumpAttachment) (err error) {
+		// names are not trusted (e.g. imported)
+		da.Href = filepath.Base(a.Name)
 
-		entry, err = CreateEntryByScan(rows)
+		fp, err := os.OpenFile(filepath.Join(dir, da.Href), os.O_CREATE|os.O_TRUNC|os.O_RDWR, d.Perm)
 		if err != nil {
 			return
 		}
 
-		err = entry.FPrintDumpDay(fp, db)
+		defer fp.Close()
+
+		_, err = a.WriteContent(d, fp)
 		if err != nil {
-			logger.err.Println(err)
-			break
+			return
 		}
+
+		if errPreview := previewAttachment(d, a, da); errPreview != nil {
+			d.logf("no preview for attachment #%d: %v\n", a.Id, errPreview)
+		}
+
+		if da.Preview == "image" {
+			da.Thumb, err = d.dumpThumbnailFile(fp, dir, fmt.Sprintf("thumb_%d.jpg", a.Id))
+			if err != nil {
+				d.logf("no thumbnail for attachment #%d: %v\n", a.Id, err)
+				da.Thumb, err = da.Href, nil
+			}
+		}
+
+		return
 	}
+}
 
-	fmt.Fprintln(fp, "</html>")
+// dumpThumbnailFile returns the name of the thumbnail of the image in fp, or
+// of the image itself if no thumbnail is needed.
+func (d *Diary) dumpThumbnailFile(fp *os.File, dir string, name string) (thumb string, err error) {
+	_, err = fp.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
 
-	return
+	path := filepath.Join(dir, name)
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, d.Perm)
+	if err != nil {
+		return
+	}
+
+	ok, err := writeThumbnail(fp, out)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+
+	if !ok {
+		os.Remove(path)
+		return filepath.Base(fp.Name()), err
+	}
+
+	return name, err
 }
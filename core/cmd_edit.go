@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func cmdEdit(d *Diary) (err error) {
+	if args.Id <= 0 {
+		err = errors.New("you must specify an id")
+		return
+	}
+
+	entry, err := RetrieveEntryByID(d, args.Id)
+	if err == NOT_FOUND {
+		err = fmt.Errorf("entry #%d not found", args.Id)
+	}
+	if err != nil {
+		return
+	}
+
+	if entry.Deleted {
+		err = fmt.Errorf("entry #%d is deleted", args.Id)
+		return
+	}
+
+	entry.Init, err = editDateTime(entry.Init, "di", args.DateInitStr, "ti", args.TimeInitStr)
+	if err != nil {
+		return fmt.Errorf("datetime init: %s", err.Error())
+	}
+
+	entry.End, err = editDateTime(entry.End, "de", args.DateEndStr, "te", args.TimeEndStr)
+	if err != nil {
+		return fmt.Errorf("datetime end: %s", err.Error())
+	}
+
+	if entry.End.Before(entry.Init) {
+		return errors.New("datetime end comes before datetime init")
+	}
+
+	if args.Set["note"] {
+		entry.Note = args.Note
+	} else {
+		entry.Note, err = editor(entry.Note)
+		if err != nil {
+			return
+		}
+	}
+
+	err = d.Update(&entry)
+	if err == nil {
+		logger.info.Printf("Entry #%d updated", entry.Id)
+	}
+
+	return
+}
+
+// Update stores the times and the note of e, which must not be deleted. Tags
+// are not changed, see Tag and Untag.
+func (d *Diary) Update(e *Entry) (err error) {
+	if e.End.Before(e.Init) {
+		return errors.New("datetime end comes before datetime init")
+	}
+
+	return d.inTx(func(dx *Diary) (err error) {
+		stored, err := RetrieveEntryByID(dx, e.Id)
+		if err == NOT_FOUND || err == nil && stored.Deleted {
+			err = fmt.Errorf("entry #%d not found", e.Id)
+		}
+
+		if err == nil {
+			err = e.Update(dx)
+		}
+
+		return
+	})
+}
+
+// editDateTime replaces the date and/or the time of t with the values of the
+// given flags, if they have been explicitly set by the user. Dates and times
+// are taken in the zone of t, the one the entry was written in.
+func editDateTime(t time.Time, dateFlag string, dateStr string, timeFlag string, timeStr string) (time.Time, error) {
+	var now = time.Now().In(t.Location())
+
+	if !args.Set[dateFlag] && !args.Set[timeFlag] {
+		return t, nil
+	}
+
+	if !args.Set[dateFlag] {
+		return setClock(t, timeStr, now, t)
+	}
+
+	if !args.Set[timeFlag] {
+		timeStr = ""
+	}
+
+	return parseDateTime(dateStr, timeStr, now, t)
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+)
+
+func cmdEncrypt(d *Diary) (err error) {
+	if d.aead != nil {
+		err = errors.New("diary is already encrypted, use rekey to change the passphrase")
+		return
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err == nil {
+		err = d.Encrypt(passphrase)
+	}
+
+	return
+}
+
+// Encrypt seals notes and attachments with a new data key, see crypto.go.
+func (d *Diary) Encrypt(passphrase string) (err error) {
+	var key = make([]byte, DATA_KEY_SIZE)
+
+	if d.aead != nil {
+		return errors.New("diary is already encrypted")
+	}
+
+	rand.Read(key)
+
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+
+	err = d.setDataKey(key)
+
+	// the index would store notes in plain text
+	if err == nil {
+		err = dropSearchIndex(tx)
+	}
+
+	if err == nil {
+		err = encryptColumn(d, tx, "entries", "note")
+	}
+
+	if err == nil {
+		err = encryptColumn(d, tx, "attachments", "content")
+	}
+
+	if err == nil {
+		err = encryptColumn(d, tx, "attachments", "sha256")
+	}
+
+	if err == nil {
+		err = encryptColumn(d, tx, "attachments", "path")
+	}
+
+	if err == nil {
+		err = encryptBlobs(d, tx)
+	}
+
+	if err == nil {
+		err = storeDataKey(tx, passphrase, key)
+	}
+
+	if err != nil {
+		d.key, d.aead = nil, nil
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		d.key, d.aead = nil, nil
+		return
+	}
+
+	d.logf("Encrypted, vacuuming\n")
+
+	// not to leave plain text in free pages
+	_, err = d.db.Exec("VACUUM")
+
+	return
+}
+
+func encryptColumn(d *Diary, tx *sql.Tx, table string, column string) (err error) {
+	var ids []int64
+
+	rows, err := tx.Query("select id from " + table)
+	if err != nil {
+		return
+	}
+
+	for rows.Next() && err == nil {
+		var id int64
+
+		err = rows.Scan(&id)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		var plain []byte
+
+		if err != nil {
+			break
+		}
+
+		err = tx.QueryRow("select "+column+" from "+table+" where id = ?", id).Scan(&plain)
+		if err == nil && plain != nil {
+			_, err = tx.Exec("update "+table+" set "+column+" = ? where id = ?", d.seal(plain), id)
+		}
+	}
+
+	return
+}
+
+// encryptBlobs seals the chunks of blobs and replaces the hashes of blobs
+// with keyed ones. Chunks are read one at a time, twice: to compute the new
+// hash, then to seal them.
+func encryptBlobs(d *Diary, tx *sql.Tx) (err error) {
+	hashes, err := querySingleStringArray(tx, "select hash from blobs")
+
+	for _, hash := range hashes {
+		var ids []int64
+		var h = d.newBlobHash()
+		var newHash string
+
+		if err != nil {
+			break
+		}
+
+		ids, err = querySingleInt64Array(tx, "select rowid from blob_chunks where hash = ? order by seq", hash)
+
+		for ix := 0; ix < len(ids) && err == nil; ix++ {
+			var data, plain []byte
+			var codec string
+
+			err = tx.QueryRow("select codec, data from blob_chunks where rowid = ?", ids[ix]).Scan(&codec, &data)
+			if err == nil {
+				plain, err = decompress(data, codec)
+			}
+
+			if err == nil {
+				h.Write(plain)
+			}
+		}
+
+		newHash = hex.EncodeToString(h.Sum(nil))
+
+		for ix := 0; ix < len(ids) && err == nil; ix++ {
+			var data []byte
+
+			err = tx.QueryRow("select data from blob_chunks where rowid = ?", ids[ix]).Scan(&data)
+			if err == nil {
+				_, err = tx.Exec("update blob_chunks set hash = ?, data = ? where rowid = ?", newHash, d.seal(data), ids[ix])
+			}
+		}
+
+		if err == nil {
+			_, err = tx.Exec("update blobs set hash = ? where hash = ?", newHash, hash)
+		}
+		if err == nil {
+			_, err = tx.Exec("update attachments set hash = ? where hash = ?", newHash, hash)
+		}
+	}
+
+	return
+}
+
+func cmdRekey(d *Diary) (err error) {
+	if d.aead == nil {
+		err = errors.New("diary is not encrypted, use encrypt")
+		return
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err == nil {
+		err = d.Rekey(passphrase)
+	}
+
+	if err == nil {
+		logger.info.Println("Passphrase changed")
+	}
+
+	return
+}
+
+// Rekey changes the passphrase of an unlocked diary. Data is not re-encrypted:
+// only the data key is sealed again.
+func (d *Diary) Rekey(passphrase string) (err error) {
+	if d.aead == nil {
+		return errors.New("diary is not encrypted or not unlocked")
+	}
+
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+
+	err = storeDataKey(tx, passphrase, d.key)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
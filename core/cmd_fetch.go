@@ -3,14 +3,12 @@
 package diary
 
 import (
-	"database/sql"
 	"errors"
+	"os"
+	"time"
 )
 
-func cmdFetch(db *sql.DB) (err error) {
-	var lengthIn int64
-	var buf []byte
-
+func cmdFetch(d *Diary) (err error) {
 	if args.OutputFile == nil {
 		err = errors.New("no file provided, use -output \"-\" to print on stdout")
 		return
@@ -21,17 +19,13 @@ func cmdFetch(db *sql.DB) (err error) {
 		return
 	}
 
-	row := db.QueryRow("SELECT length(content) from attachments where id = ?", args.Id)
-	err = row.Scan(&lengthIn)
-
-	if err == nil {
-		buf = make([]byte, lengthIn)
-
-		row := db.QueryRow("SELECT content from attachments where id = ?", args.Id)
-		err = row.Scan(&buf)
+	attachment, err := d.Fetch(args.Id, args.OutputFile)
 
-		if err == nil {
-			args.OutputFile.Write(buf)
+	if err == nil && args.Mtime {
+		if args.OutputFile == os.Stdout || attachment.Modified.IsZero() {
+			logger.warn.Println("modification time not restored")
+		} else {
+			err = os.Chtimes(args.OutputFile.Name(), time.Time{}, attachment.Modified)
 		}
 	}
 
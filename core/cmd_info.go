@@ -3,28 +3,38 @@
 package diary
 
 import (
-	"database/sql"
 	"fmt"
 	"os"
 )
 
-func cmdInfo(db *sql.DB) (err error) {
+func cmdInfo(d *Diary) (err error) {
 	var tmp []int64
 	var stat os.FileInfo
 
-	tmp, err = querySingleInt64Array(db, "select count(*) from entries;")
-	if te := tmp[0]; err == nil {
+	tmp, err = querySingleInt64Array(d.db, "select count(*) from entries;")
+	if err == nil {
+		var te = tmp[0]
+
 		fmt.Printf("Total entries:     %d\n", te)
 
-		tmp, err = querySingleInt64Array(db, "select count(*) from attachments;")
-		if ta := tmp[0]; err == nil {
+		tmp, err = querySingleInt64Array(d.db, "select count(*) from attachments;")
+		if err == nil {
+			var ta = tmp[0]
+
 			fmt.Printf("Total attachments: %d (avg. %.2f p.e.)\n", ta, float64(ta)/float64(te))
 
-			tmp, err = querySingleInt64Array(db, "select sum(length(content)) from attachments;")
+			tmp, err = querySingleInt64Array(d.db, "select coalesce(sum("+ATTACHMENT_SIZE+"), 0) from "+ATTACHMENT_FROM+";")
 			if err == nil {
-				fmt.Printf("Blob total size:   %s\n", sizeNorm(tmp[0]))
+				var logical = tmp[0]
 
-				stat, err = os.Stat(args.Path)
+				tmp, err = querySingleInt64Array(d.db, "select coalesce((select sum(length(data)) from blob_chunks), 0) + coalesce((select sum(length(content)) from attachments), 0) + coalesce((select sum(length(content)) from blobs), 0);")
+				if err == nil {
+					fmt.Printf("Blob total size:   %s logical, %s physical\n", sizeNorm(logical), sizeNorm(tmp[0]))
+				}
+			}
+
+			if err == nil {
+				stat, err = os.Stat(d.Path())
 				if err == nil {
 					fmt.Printf("DB size:           %s\n", sizeNorm(stat.Size()))
 
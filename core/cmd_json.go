@@ -0,0 +1,440 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const JSON_VERSION = 1
+
+// A document is an object: {"version", "exported", "entries"}, entries last.
+// It is written, and read, one entry at a time: only an entry, with its
+// attachments, is held in memory.
+
+type jsonEntry struct {
+	Id          int64            `json:"id"`
+	Init        time.Time        `json:"init"`
+	End         time.Time        `json:"end"`
+	Zone        string           `json:"zone,omitempty"` // where the entry was written
+	Inserted    time.Time        `json:"inserted"`
+	Note        string           `json:"note"`
+	Deleted     bool             `json:"deleted"`
+	DeletedAt   time.Time        `json:"deleted_at,omitzero"`
+	Tags        []string         `json:"tags"`
+	Attachments []jsonAttachment `json:"attachments"`
+}
+
+type jsonAttachment struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Inserted  time.Time `json:"inserted"`
+	Deleted   bool      `json:"deleted"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	Mime      string    `json:"mime,omitempty"`
+	Sha256    string    `json:"sha256,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Modified  time.Time `json:"modified,omitzero"`
+	Content   []byte    `json:"content"` // base64
+}
+
+func cmdExportJSON(d *Diary) (err error) {
+	if args.OutputFile == nil {
+		err = errors.New("no file provided, use -output \"-\" to print on stdout")
+		return
+	}
+
+	n, err := d.ExportJSON(args.OutputFile)
+	if err == nil {
+		logger.info.Printf("%d entries exported\n", n)
+	}
+
+	return
+}
+
+// ExportJSON writes all the entries, deleted or not, with their tags and
+// attachments, to w as a JSON document; it returns how many entries were
+// written. Contents are encoded in base64.
+func (d *Diary) ExportJSON(w io.Writer) (n int, err error) {
+	exported, err := json.Marshal(time.Now())
+	if err == nil {
+		_, err = fmt.Fprintf(w, "{\n  \"version\": %d,\n  \"exported\": %s,\n  \"entries\": [", JSON_VERSION, exported)
+	}
+	if err != nil {
+		return
+	}
+
+	rows, err := d.db.Query(QUERY_ENTRY_ALL + " order by id")
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var entry Entry
+		var je jsonEntry
+		var buf []byte
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			je, err = exportEntry(d, entry)
+		}
+		if err == nil {
+			buf, err = json.MarshalIndent(je, "    ", "  ")
+		}
+
+		if err == nil && n > 0 {
+			_, err = io.WriteString(w, ",")
+		}
+		if err == nil {
+			_, err = io.WriteString(w, "\n    ")
+		}
+		if err == nil {
+			_, err = w.Write(buf)
+		}
+		if err == nil {
+			n++
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err == nil && n > 0 {
+		_, err = io.WriteString(w, "\n  ")
+	}
+	if err == nil {
+		_, err = io.WriteString(w, "]\n}\n")
+	}
+
+	return
+}
+
+func exportEntry(d *Diary, entry Entry) (je jsonEntry, err error) {
+	err = entry.RetrieveTags(d)
+	if err != nil {
+		return
+	}
+
+	je = jsonEntry{
+		Id:          entry.Id,
+		Init:        entry.Init,
+		End:         entry.End,
+		Zone:        zoneName(entry.Init),
+		Inserted:    entry.Inserted,
+		Note:        entry.Note,
+		Deleted:     entry.Deleted,
+		DeletedAt:   entry.DeletedAt,
+		Tags:        append([]string{}, entry.Tags...),
+		Attachments: []jsonAttachment{},
+	}
+
+	attachments, err := entryAttachments(d, entry.Id)
+
+	// contents are loaded one at a time, once the query is done
+	for ix := 0; ix < len(attachments) && err == nil; ix++ {
+		var attachment = attachments[ix]
+
+		err = attachment.RetrieveContent(d)
+		if err == nil {
+			je.Attachments = append(je.Attachments, jsonAttachment{
+				Id:        attachment.Id,
+				Name:      attachment.Name,
+				Inserted:  attachment.Inserted,
+				Deleted:   attachment.Deleted,
+				DeletedAt: attachment.DeletedAt,
+				Mime:      attachment.Mime,
+				Sha256:    attachment.Sha256,
+				Path:      attachment.Path,
+				Modified:  attachment.Modified,
+				Content:   attachment.Content,
+			})
+		}
+	}
+
+	return
+}
+
+// entryAttachments returns the attachments of an entry, deleted or not,
+// without content.
+func entryAttachments(d *Diary, entryId int64) (list []Attachment, err error) {
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.entry_id = ? order by a.id", entryId)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var a Attachment
+
+		a, err = CreateAttachmentByScanNC(d, rows)
+		if err == nil {
+			list = append(list, a)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+// ImportStats counts what ImportJSON imports and skips.
+type ImportStats struct {
+	Entries, EntriesSkipped         int
+	Attachments, AttachmentsSkipped int
+}
+
+func cmdImportJSON(d *Diary) (err error) {
+	if args.InputFileStr == "" {
+		err = errors.New("no file provided: diary import-json FILE")
+		return
+	}
+
+	fp, err := os.Open(args.InputFileStr)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+
+	stats, err := d.ImportJSON(fp, args.Merge)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Entries:     %d imported, %d skipped\n", stats.Entries, stats.EntriesSkipped)
+	fmt.Printf("Attachments: %d imported, %d skipped\n", stats.Attachments, stats.AttachmentsSkipped)
+
+	return
+}
+
+// ImportJSON adds the entries of a document written by ExportJSON, read from
+// r, with new ids. If merge is set, entries and attachments already in the
+// diary are skipped. Nothing is imported if an error occurs.
+func (d *Diary) ImportJSON(r io.Reader, merge bool) (stats ImportStats, err error) {
+	// all or nothing: a failed import can be retried as it is
+	err = d.inTx(func(dx *Diary) (err error) {
+		stats, err = importDocument(dx, json.NewDecoder(r), merge)
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("%s (nothing imported)", err.Error())
+	}
+
+	return
+}
+
+// importDocument reads the document from dec, importing entries as they are
+// decoded. The version must come before them.
+func importDocument(d *Diary, dec *json.Decoder, merge bool) (stats ImportStats, err error) {
+	var version int
+
+	err = expectDelim(dec, '{')
+
+	for err == nil && dec.More() {
+		var key json.Token
+
+		key, err = dec.Token()
+		if err != nil {
+			break
+		}
+
+		switch key {
+		case "version":
+			err = dec.Decode(&version)
+		case "entries":
+			if version == 0 {
+				err = errors.New("invalid document: the version must come before the entries")
+				break
+			}
+
+			if version != JSON_VERSION {
+				err = fmt.Errorf("unsupported document version: %d", version)
+				break
+			}
+
+			err = expectDelim(dec, '[')
+			for err == nil && dec.More() {
+				var je jsonEntry
+
+				err = dec.Decode(&je)
+				if err == nil {
+					err = importJSONEntry(d, je, merge, &stats)
+				}
+			}
+
+			if err == nil {
+				err = expectDelim(dec, ']')
+			}
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+	}
+
+	if err == nil {
+		err = expectDelim(dec, '}')
+	}
+
+	if err == nil && version != JSON_VERSION {
+		err = fmt.Errorf("unsupported document version: %d", version)
+	}
+
+	return
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) (err error) {
+	token, err := dec.Token()
+	if err == nil && token != delim {
+		err = fmt.Errorf("invalid document: expected %s, found %v", delim, token)
+	}
+
+	return
+}
+
+func importJSONEntry(d *Diary, je jsonEntry, merge bool, stats *ImportStats) (err error) {
+	var entry = Entry{
+		Id:        -1,
+		Init:      je.Init,
+		End:       je.End,
+		Inserted:  je.Inserted,
+		Note:      je.Note,
+		Deleted:   je.Deleted,
+		DeletedAt: je.DeletedAt,
+	}
+
+	// times only keep the offset, older documents have no zone
+	if je.Zone != "" {
+		loc := parseZone(je.Zone)
+		entry.Init, entry.End = entry.Init.In(loc), entry.End.In(loc)
+	}
+
+	if merge {
+		entry.Id, err = findDuplicateEntry(d, entry)
+	}
+
+	if err == nil && entry.Id == -1 {
+		err = importEntry(d, &entry)
+		stats.Entries++
+	} else if err == nil {
+		d.logf("Entry #%d already present as #%d\n", je.Id, entry.Id)
+		stats.EntriesSkipped++
+	}
+
+	if err == nil && len(je.Tags) > 0 {
+		err = entry.AddTags(d, je.Tags)
+	}
+
+	for ix := 0; ix < len(je.Attachments) && err == nil; ix++ {
+		var duplicate bool
+		var ja = je.Attachments[ix]
+		var attachment = Attachment{
+			Name:      ja.Name,
+			Inserted:  ja.Inserted,
+			EntryId:   entry.Id,
+			Deleted:   ja.Deleted,
+			DeletedAt: ja.DeletedAt,
+			Path:      ja.Path,
+			Modified:  ja.Modified,
+			Content:   ja.Content,
+		}
+
+		if merge {
+			duplicate, err = isDuplicateAttachment(d, attachment)
+		}
+
+		if err == nil && duplicate {
+			stats.AttachmentsSkipped++
+		} else if err == nil {
+			err = importAttachment(d, &attachment)
+			stats.Attachments++
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("entry #%d: %s", je.Id, err.Error())
+	}
+
+	return
+}
+
+// importEntry inserts entry, then deletes it if entry.Deleted, at
+// entry.DeletedAt: older documents do not have it, their retention period
+// starts now.
+func importEntry(d *Diary, entry *Entry) (err error) {
+	var deleted, deletedAt = entry.Deleted, deletionTime(entry.DeletedAt)
+
+	err = entry.Insert(d)
+	if err == nil && entry.Id == -1 {
+		err = errors.New("could not retrieve id")
+	}
+
+	if err == nil && deleted {
+		_, err = d.db.Exec("update entries set deleted = 1, deleted_at = ? where id = ?", deletedAt.Unix(), entry.Id)
+		entry.Deleted, entry.DeletedAt = true, deletedAt
+	}
+
+	return
+}
+
+// importAttachment is importEntry for attachments.
+func importAttachment(d *Diary, attachment *Attachment) (err error) {
+	var deleted, deletedAt = attachment.Deleted, deletionTime(attachment.DeletedAt)
+
+	err = attachment.Insert(d)
+
+	if err == nil && deleted {
+		_, err = d.db.Exec("update attachments set deleted = 1, deleted_at = ? where id = ?", deletedAt.Unix(), attachment.Id)
+		attachment.Deleted, attachment.DeletedAt = true, deletedAt
+	}
+
+	return
+}
+
+func deletionTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+
+	return t
+}
+
+// findDuplicateEntry returns the id of an entry equal to e, -1 if none.
+// Notes are compared after decryption.
+func findDuplicateEntry(d *Diary, e Entry) (id int64, err error) {
+	id = -1
+
+	rows, err := d.db.Query(QUERY_ENTRY_ALL+" where init = ? and fin = ? and inserted = ?", e.Init.Unix(), e.End.Unix(), e.Inserted.Unix())
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for id == -1 && rows.Next() && err == nil {
+		var candidate Entry
+
+		candidate, err = CreateEntryByScan(d, rows)
+		if err == nil && candidate.Note == e.Note {
+			id = candidate.Id
+		}
+	}
+
+	return
+}
+
+// isDuplicateAttachment compares contents by their blob hash.
+func isDuplicateAttachment(d *Diary, a Attachment) (duplicate bool, err error) {
+	var count int64
+
+	err = d.db.QueryRow("select count(*) from attachments where entry_id = ? and name = ? and inserted = ? and hash = ?", a.EntryId, a.Name, a.Inserted.Unix(), d.blobHash(a.Content)).Scan(&count)
+	duplicate = count > 0
+
+	return
+}
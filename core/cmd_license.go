@@ -3,15 +3,13 @@
 package diary
 
 import (
-	"database/sql"
-
 	_ "embed"
 )
 
 //go:embed res/license_info.txt
 var LICENSE_INFO string
 
-func cmdLicense(_ *sql.DB) (_ error) {
+func cmdLicense(_ *Diary) (_ error) {
 	print(LICENSE_INFO)
 	return
 }
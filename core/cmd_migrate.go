@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"fmt"
+)
+
+func cmdMigrate(d *Diary) (err error) {
+	version, pending, err := pendingMigrations(d.pool)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Schema version: %d\n", version)
+
+	if len(pending) == 0 {
+		fmt.Println("Up to date")
+		return
+	}
+
+	for _, mx := range pending {
+		if args.DryRun {
+			fmt.Printf("Pending %04d_%s\n", mx.Version, mx.Name)
+			continue
+		}
+
+		fmt.Printf("Applying %04d_%s\n", mx.Version, mx.Name)
+
+		err = mx.Apply(d.pool)
+		if err != nil {
+			break
+		}
+	}
+
+	return
+}
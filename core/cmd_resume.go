@@ -3,32 +3,109 @@
 package diary
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
-func cmdResume(db *sql.DB) (err error) {
-	date, _ := time.ParseInLocation(time.DateOnly, args.DateInit.Format(time.DateOnly), time.Now().Location())
+func cmdResume(d *Diary) (err error) {
+	var day string
 
-	rows, err := db.Query(QUERY_ENTRY_ALL+" where init >= ? and init < ? and deleted = 0 order by init", date.Unix(), date.Add(24*time.Hour).Unix())
+	from, to, err := resumeRange(d)
 	if err != nil {
 		return
 	}
 
-	defer rows.Close()
-	for rows.Next() && err == nil {
-		var entry Entry
+	entries, err := d.Entries(from, to, args.Tags)
 
-		entry, err = CreateEntryByScan(rows)
-		if err != nil {
-			break
+	for _, entry := range entries {
+		// grouped by day, entries are sorted
+		start := entry.Init.In(d.Zone)
+
+		if dx := start.Format(time.DateOnly); dx != day {
+			day = dx
+
+			n, _ := fmt.Printf("%s, %s\n", day, start.Weekday())
+			printLine(n-1, '=', os.Stdout)
+			fmt.Println()
 		}
 
-		entry.FPrintResume(db, os.Stdout)
+		entry.FPrintResume(d, os.Stdout)
 		fmt.Fprintln(os.Stdout)
 	}
 
 	return
 }
+
+// resumeRange returns the days to show: from the day of -di to the day of
+// -de, both included, unless a span is given. Days are bucketed in d.Zone.
+func resumeRange(d *Diary) (from time.Time, to time.Time, err error) {
+	var spans int
+
+	for _, set := range []bool{args.Week, args.Month, args.Last != ""} {
+		if set {
+			spans++
+		}
+	}
+
+	if spans > 1 {
+		err = errors.New("use only one of -week, -month and -last")
+		return
+	}
+
+	if spans > 0 && (args.Set["de"] || args.Set["to"]) {
+		err = errors.New("-de cannot be used with -week, -month and -last")
+		return
+	}
+
+	from = d.dayStart(args.DateInit)
+	to = d.dayStart(args.DateEnd).AddDate(0, 0, 1)
+
+	switch {
+	case args.Week:
+		// weeks start on Monday
+		from = from.AddDate(0, 0, -(int(from.Weekday())+6)%7)
+		to = from.AddDate(0, 0, 7)
+	case args.Month:
+		from = from.AddDate(0, 0, 1-from.Day())
+		to = from.AddDate(0, 1, 0)
+	case args.Last != "":
+		// the last n days (or weeks, months) up to -di included
+		to = from.AddDate(0, 0, 1)
+		from, err = spanBefore(to, args.Last)
+	}
+
+	if err == nil && !to.After(from) {
+		err = errors.New("end date before init date")
+	}
+
+	return
+}
+
+// spanBefore returns the time span before t, given as a number followed by
+// d (days), w (weeks) or m (months): 7d, 2w, 1m.
+func spanBefore(t time.Time, span string) (from time.Time, err error) {
+	if len(span) < 2 {
+		return from, fmt.Errorf("invalid span: %s", span)
+	}
+
+	n, err := strconv.Atoi(span[:len(span)-1])
+	if err != nil || n <= 0 {
+		return from, fmt.Errorf("invalid span: %s", span)
+	}
+
+	switch span[len(span)-1] {
+	case 'd':
+		from = t.AddDate(0, 0, -n)
+	case 'w':
+		from = t.AddDate(0, 0, -7*n)
+	case 'm':
+		from = t.AddDate(0, -n, 0)
+	default:
+		err = fmt.Errorf("invalid span: %s (units: d, w, m)", span)
+	}
+
+	return
+}
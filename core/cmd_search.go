@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+//go:embed res/search.sql
+var searchSchema string
+
+// Changes to the search index are numbered scripts, applied once the index
+// exists: the version is stored in metadata as search_version.
+//
+//go:embed res/search/*.sql
+var searchFS embed.FS
+
+const QUERY_SEARCH = `select e.id, e.init, e.fin, e.inserted, snippet(entries_fts, -1, ?, ?, '...', 16), e.deleted, e.deleted_at, e.zone
+	from entries_fts join entries e on e.id = entries_fts.rowid
+	where entries_fts match ? and e.deleted = 0
+	order by entries_fts.rank`
+
+func touchSearchIndex(d *Diary) (err error) {
+	var count int64
+	var version int64
+
+	err = d.db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = 'entries_fts'").Scan(&count)
+	if err != nil {
+		return
+	}
+
+	scripts, err := loadScripts(searchFS, "res/search")
+	if err != nil {
+		return
+	}
+
+	if count > 0 {
+		value, errM := getMetadata(d, "search_version")
+		if errM == nil {
+			version, errM = strconv.ParseInt(string(value), 10, 64)
+		}
+
+		if errM != nil && errM != NOT_FOUND {
+			return errM
+		}
+
+		if version >= int64(len(scripts)) {
+			return
+		}
+
+		d.logf("Search index is out of date: updating\n")
+	} else {
+		d.logf("Search index does not exist: creating\n")
+	}
+
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+
+	if count == 0 {
+		_, err = tx.Exec(searchSchema)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("could not create search index (is SQLite built with FTS5?): %s", err.Error())
+			return
+		}
+	}
+
+	for _, sx := range scripts[version:] {
+		_, err = tx.Exec(sx.Script)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("search index %04d_%s: %s", sx.Version, sx.Name, err.Error())
+		}
+	}
+
+	err = setMetadata(tx, "search_version", len(scripts))
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+
+	return
+}
+
+func dropSearchIndex(tx *sql.Tx) (err error) {
+	for _, trigger := range []string{"entries_fts_ai", "entries_fts_au", "entries_fts_ad", "attachments_fts_ai", "attachments_fts_au", "attachments_fts_ad"} {
+		_, err = tx.Exec("drop trigger if exists " + trigger)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.Exec("drop table if exists entries_fts")
+	if err == nil {
+		_, err = tx.Exec("delete from metadata where key = 'search_version'")
+	}
+
+	return
+}
+
+func cmdSearch(d *Diary) (err error) {
+	hits, err := d.Search(args.Query, "\033[1;33m", "\033[0m")
+
+	for _, ex := range hits {
+		ex.FPrintResume(d, os.Stdout)
+		fmt.Fprintln(os.Stdout)
+	}
+
+	if err == nil {
+		logger.info.Printf("%d hit(s)\n", len(hits))
+	}
+
+	return
+}
+
+// Search returns the entries, not deleted, matching query (FTS5 syntax), best
+// first. Their Note is a snippet of the match, the matching terms between
+// markOpen and markClose. The search index is created, or updated, if needed.
+func (d *Diary) Search(query string, markOpen string, markClose string) (hits []Entry, err error) {
+	if d.aead != nil {
+		err = errors.New("search is not available on encrypted diaries")
+		return
+	}
+
+	if query == "" {
+		err = errors.New("you must specify a query")
+		return
+	}
+
+	err = touchSearchIndex(d)
+	if err != nil {
+		return
+	}
+
+	rows, err := d.db.Query(QUERY_SEARCH, markOpen, markClose, query)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var entry Entry
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			hits = append(hits, entry)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
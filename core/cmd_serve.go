@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cmdServe serves the hierarchy written by dump, rendered on each request.
+// Attachments are served from the database:
+//
+//	/attachment/{id}/{name}
+//	/thumb/{id}
+func cmdServe(d *Diary) (err error) {
+	// loaded once, before handlers run concurrently
+	_, err = d.loadDumpTemplates()
+	if err != nil {
+		return
+	}
+
+	logger.info.Printf("Serving on http://%s/\n", args.Addr)
+
+	return newHTTPServer(args.Addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveDiary(d, w, r, args.Tags)
+	})).ListenAndServe()
+}
+
+// newHTTPServer is the server of serve and serve-api: slow or idle clients
+// are dropped. Writing is given long enough for large attachments.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Minute,
+		WriteTimeout:      30 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// serveDiary serves the entries having at least one of tags, if any.
+func serveDiary(d *Diary, w http.ResponseWriter, r *http.Request, tags []string) {
+	var err error
+	var parts = strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if args.Verbose {
+		logger.info.Printf("%s %s\n", r.Method, r.URL.Path)
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "attachment":
+		err = serveAttachment(d, w, r, parts[1], tags)
+	case len(parts) == 2 && parts[0] == "thumb":
+		err = serveThumbnail(d, w, r, parts[1], tags)
+	default:
+		err = servePage(d, w, parts, tags)
+	}
+
+	if err == NOT_FOUND {
+		http.NotFound(w, r)
+	} else if err != nil {
+		logger.err.Printf("%s: %v\n", r.URL.Path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+// servePage serves index, year, month and day pages: /[year/[month/[day/]]]
+func servePage(d *Diary, w http.ResponseWriter, parts []string, tags []string) (err error) {
+	var page DumpPage
+	var name string
+	var nums []int64
+	var buf bytes.Buffer
+
+	if parts[len(parts)-1] == "index.html" || parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+
+	for _, part := range parts {
+		n, errN := strconv.ParseInt(part, 10, 64)
+		if errN != nil {
+			return NOT_FOUND
+		}
+
+		nums = append(nums, n)
+	}
+
+	switch len(nums) {
+	case 0:
+		name = "dump_index"
+		page, _, err = dumpIndexPage(d, tags)
+	case 1:
+		name = "dump_index"
+		page, _, err = dumpYearPage(d, tags, nums[0])
+	case 2:
+		name = "dump_month"
+		page, _, err = dumpMonthPage(d, tags, nums[0], nums[1])
+	case 3:
+		name = "dump_day"
+		date := time.Date(int(nums[0]), time.Month(nums[1]), int(nums[2]), 0, 0, 0, 0, d.Zone)
+		page, err = dumpDayPage(d, date, tags, serveAttachmentLink)
+	default:
+		err = NOT_FOUND
+	}
+
+	// rendered before writing, not to send half a page on errors
+	if err == nil {
+		err = d.executeDumpPage(&buf, name, page)
+	}
+
+	if err == nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err = buf.WriteTo(w)
+	}
+
+	return
+}
+
+// serveAttachmentLink is the AttachmentDumper of served pages.
+func serveAttachmentLink(d *Diary, a Attachment, da *DumpAttachment) (err error) {
+	da.Href = attachmentURL(a)
+
+	if errPreview := previewAttachment(d, a, da); errPreview != nil {
+		logger.warn.Printf("no preview for attachment #%d: %v\n", a.Id, errPreview)
+	}
+
+	if da.Preview == "image" {
+		da.Thumb = fmt.Sprintf("/thumb/%d", a.Id)
+	}
+
+	return
+}
+
+func attachmentURL(a Attachment) string {
+	return fmt.Sprintf("/attachment/%d/%s", a.Id, url.PathEscape(filepath.Base(a.Name)))
+}
+
+// servedAttachment retrieves an attachment that would be shown in pages:
+// neither it nor its entry is deleted, and the entry has one of tags, if any.
+func servedAttachment(d *Diary, idStr string, tags []string) (a Attachment, err error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return a, NOT_FOUND
+	}
+
+	tagClause, tagParams := tagFilter(tags)
+
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.id = ? and a.deleted = 0 and a.entry_id in (select id from entries where deleted = 0"+tagClause+")", append([]any{id}, tagParams...)...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return a, NOT_FOUND
+	}
+
+	return CreateAttachmentByScanNC(d, rows)
+}
+
+func serveAttachment(d *Diary, w http.ResponseWriter, r *http.Request, idStr string, tags []string) (err error) {
+	var mime = "application/octet-stream"
+
+	a, err := servedAttachment(d, idStr, tags)
+	if err != nil {
+		return
+	}
+
+	if notModified(w, r, a.Sha256, "") {
+		return
+	}
+
+	if a.Mime != "" {
+		mime = a.Mime
+	}
+
+	w.Header().Set("Content-Type", mime)
+	// plain size of the blob, which WriteContent checks the content against
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	// attached HTML must not run as part of the diary
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, err = a.WriteContent(d, w)
+	if err != nil {
+		// headers are gone, just log
+		logger.err.Printf("attachment #%d: %v\n", a.Id, err)
+	}
+
+	return nil
+}
+
+// serveThumbnail scales images down on each request: clients are told to
+// keep them, and to ask again only if they changed (see notModified).
+func serveThumbnail(d *Diary, w http.ResponseWriter, r *http.Request, idStr string, tags []string) (err error) {
+	var buf bytes.Buffer
+	var ok bool
+
+	a, err := servedAttachment(d, idStr, tags)
+	if err != nil {
+		return
+	}
+
+	if notModified(w, r, a.Sha256, "thumb-") {
+		return
+	}
+
+	err = a.RetrieveContent(d)
+	if err == nil {
+		ok, err = writeThumbnail(bytes.NewReader(a.Content), &buf)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if !ok {
+		w.Header().Del("ETag")
+		http.Redirect(w, r, attachmentURL(a), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	_, err = buf.WriteTo(w)
+
+	return
+}
+
+// notModified sets the ETag of a response made from the content whose SHA-256
+// is sum, and answers 304 Not Modified if the client has it already. Contents
+// of attachments never change, but they must be asked for again: they may be
+// deleted meanwhile. Older attachments without SHA-256 are not cached.
+func notModified(w http.ResponseWriter, r *http.Request, sum string, prefix string) bool {
+	if sum == "" {
+		return false
+	}
+
+	etag := `"` + prefix + sum + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if strings.TrimSpace(match) == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,589 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Clients authenticate with "Authorization: Bearer <token>"
+const API_TOKEN_ENV = "DIARY_API_TOKEN"
+
+type apiEntry struct {
+	Id          int64           `json:"id"`
+	Init        time.Time       `json:"init"`
+	End         time.Time       `json:"end"`
+	Inserted    time.Time       `json:"inserted"`
+	Note        string          `json:"note"`
+	Tags        []string        `json:"tags"`
+	Attachments []apiAttachment `json:"attachments"`
+}
+
+type apiAttachment struct {
+	Id       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Inserted time.Time `json:"inserted"`
+	Size     int64     `json:"size"`
+	Mime     string    `json:"mime,omitempty"`
+	Sha256   string    `json:"sha256,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+// Body of create and update: missing fields are left as they are (update)
+// or get a default (create).
+type apiEntryInput struct {
+	Init *time.Time `json:"init"`
+	End  *time.Time `json:"end"`
+	Note *string    `json:"note"`
+	Tags *[]string  `json:"tags"`
+}
+
+// An apiError is sent to the client as {"error": ...} with its status.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, a ...any) apiError {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+type apiHandler func(d *Diary, w http.ResponseWriter, r *http.Request) error
+
+func cmdServeAPI(d *Diary) (err error) {
+	var token = os.Getenv(API_TOKEN_ENV)
+	var mux = http.NewServeMux()
+
+	if token == "" {
+		return fmt.Errorf("no token: set %s", API_TOKEN_ENV)
+	}
+
+	if (args.CertFile == "") != (args.KeyFile == "") {
+		return errors.New("-cert and -key must be given together")
+	}
+
+	// the token must not travel in clear
+	if args.CertFile == "" && !isLoopback(args.Addr) {
+		return fmt.Errorf("%s is not a loopback address: use -cert and -key", args.Addr)
+	}
+
+	var routes = []struct {
+		pattern string
+		handler apiHandler
+	}{
+		{"GET /api/entries", apiListEntries},
+		{"POST /api/entries", apiCreateEntry},
+		{"GET /api/entries/{id}", apiGetEntry},
+		{"PUT /api/entries/{id}", apiUpdateEntry},
+		{"DELETE /api/entries/{id}", apiDeleteEntry},
+		{"POST /api/entries/{id}/attachments", apiUploadAttachment},
+		{"GET /api/attachments/{id}", apiDownloadAttachment},
+		{"DELETE /api/attachments/{id}", apiDeleteAttachment},
+	}
+
+	for _, route := range routes {
+		mux.Handle(route.pattern, apiAuth(d, token, route.handler))
+	}
+
+	if args.CertFile != "" {
+		logger.info.Printf("Serving API on https://%s/api/\n", args.Addr)
+		return newHTTPServer(args.Addr, mux).ListenAndServeTLS(args.CertFile, args.KeyFile)
+	}
+
+	logger.info.Printf("Serving API on http://%s/api/\n", args.Addr)
+
+	return newHTTPServer(args.Addr, mux).ListenAndServe()
+}
+
+// isLoopback tells whether addr, host:port, can only be reached from this
+// machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// apiAuth checks the bearer token, then runs h and reports its error.
+func apiAuth(d *Diary, token string, h apiHandler) http.Handler {
+	var expected = []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		if args.Verbose {
+			logger.info.Printf("%s %s\n", r.Method, r.URL.Path)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			err = apiError{http.StatusUnauthorized, "unauthorized"}
+		} else {
+			err = h(d, w, r)
+		}
+
+		var ae apiError
+
+		switch {
+		case err == nil:
+			return
+		case err == NOT_FOUND:
+			ae = apiError{http.StatusNotFound, "not found"}
+		case errors.As(err, &ae):
+		default:
+			logger.err.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+			ae = apiError{http.StatusInternalServerError, "internal error"}
+		}
+
+		writeJSON(w, ae.status, map[string]string{"error": ae.message})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+func pathId(r *http.Request) (id int64, err error) {
+	id, err = strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		err = NOT_FOUND
+	}
+
+	return
+}
+
+// apiTime parses a date (midnight in d.Zone) or an RFC 3339 date and time.
+func apiTime(d *Diary, s string) (t time.Time, dateOnly bool, err error) {
+	t, err = time.ParseInLocation(time.DateOnly, s, d.Zone)
+	if err == nil {
+		return t, true, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+	return
+}
+
+// apiListEntries lists entries, optionally filtered by ?from=&to= (dates are
+// inclusive) and ?tag=.
+func apiListEntries(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var where = " where deleted = 0"
+	var params []any
+	var entries = []apiEntry{}
+	var q = r.URL.Query()
+
+	if s := q.Get("from"); s != "" {
+		from, _, errT := apiTime(d, s)
+		if errT != nil {
+			return badRequest("from: %s", errT.Error())
+		}
+
+		where += " and init >= ?"
+		params = append(params, from.Unix())
+	}
+
+	if s := q.Get("to"); s != "" {
+		to, dateOnly, errT := apiTime(d, s)
+		if errT != nil {
+			return badRequest("to: %s", errT.Error())
+		}
+
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+			where += " and init < ?"
+		} else {
+			where += " and init <= ?"
+		}
+
+		params = append(params, to.Unix())
+	}
+
+	var tags []string
+	for _, tx := range q["tag"] {
+		tags = append(tags, normTag(tx))
+	}
+
+	tagClause, tagParams := tagFilter(tags)
+
+	rows, err := d.db.Query(QUERY_ENTRY_ALL+where+tagClause+" order by init", append(params, tagParams...)...)
+	if err != nil {
+		return
+	}
+
+	var list []Entry
+	for rows.Next() && err == nil {
+		var entry Entry
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			list = append(list, entry)
+		}
+	}
+	rows.Close()
+
+	for _, entry := range list {
+		var ae apiEntry
+
+		if err != nil {
+			return
+		}
+
+		ae, err = newAPIEntry(d, entry)
+		if err == nil {
+			entries = append(entries, ae)
+		}
+	}
+
+	if err == nil {
+		err = writeJSON(w, http.StatusOK, entries)
+	}
+
+	return
+}
+
+func newAPIEntry(d *Diary, entry Entry) (ae apiEntry, err error) {
+	err = entry.RetrieveTags(d)
+	if err != nil {
+		return
+	}
+
+	ae = apiEntry{
+		Id:          entry.Id,
+		Init:        entry.Init,
+		End:         entry.End,
+		Inserted:    entry.Inserted,
+		Note:        entry.Note,
+		Tags:        append([]string{}, entry.Tags...),
+		Attachments: []apiAttachment{},
+	}
+
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.entry_id = ? and a.deleted = 0 order by a.inserted", entry.Id)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var a Attachment
+
+		a, err = CreateAttachmentByScanNC(d, rows)
+		if err == nil {
+			ae.Attachments = append(ae.Attachments, apiAttachment{
+				Id:       a.Id,
+				Name:     a.Name,
+				Inserted: a.Inserted,
+				Size:     a.Size,
+				Mime:     a.Mime,
+				Sha256:   a.Sha256,
+				Path:     a.Path,
+				Modified: a.Modified,
+			})
+		}
+	}
+
+	return
+}
+
+// apiEntryByID retrieves an entry that is not deleted.
+func apiEntryByID(d *Diary, r *http.Request) (entry Entry, err error) {
+	id, err := pathId(r)
+	if err == nil {
+		entry, err = RetrieveEntryByID(d, id)
+	}
+
+	if err == nil && entry.Deleted {
+		err = NOT_FOUND
+	}
+
+	return
+}
+
+func apiGetEntry(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var ae apiEntry
+
+	entry, err := apiEntryByID(d, r)
+	if err == nil {
+		ae, err = newAPIEntry(d, entry)
+	}
+
+	if err == nil {
+		err = writeJSON(w, http.StatusOK, ae)
+	}
+
+	return
+}
+
+func readEntryInput(r *http.Request) (in apiEntryInput, err error) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if errDec := dec.Decode(&in); errDec != nil {
+		err = badRequest("invalid body: %s", errDec.Error())
+	}
+
+	return
+}
+
+// apply sets the given fields of entry, and returns its new tags (nil if
+// they are not given).
+func (in apiEntryInput) apply(entry *Entry) (tags []string, err error) {
+	if in.Init != nil {
+		entry.Init = *in.Init
+	}
+
+	if in.End != nil {
+		entry.End = *in.End
+	}
+
+	if in.Note != nil {
+		entry.Note = *in.Note
+	}
+
+	if entry.End.Before(entry.Init) {
+		err = badRequest("end is before init")
+	}
+
+	if in.Tags != nil {
+		tags = []string{}
+
+		for _, tx := range *in.Tags {
+			if tx = normTag(tx); tx != "" && !slices.Contains(tags, tx) {
+				tags = append(tags, tx)
+			}
+		}
+	}
+
+	return
+}
+
+// setTags makes tags the tags of entry.
+func setTags(d *Diary, entry *Entry, tags []string) (err error) {
+	var removed []string
+
+	err = entry.RetrieveTags(d)
+	if err != nil {
+		return
+	}
+
+	for _, tx := range entry.Tags {
+		if !slices.Contains(tags, tx) {
+			removed = append(removed, tx)
+		}
+	}
+
+	_, err = entry.RemoveTags(d, removed)
+	if err == nil {
+		err = entry.AddTags(d, tags)
+	}
+
+	return
+}
+
+func apiCreateEntry(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var entry Entry
+	var tags []string
+	var ae apiEntry
+
+	in, err := readEntryInput(r)
+	if err != nil {
+		return
+	}
+
+	if in.Init == nil {
+		now := time.Now()
+		in.Init = &now
+	}
+
+	if in.End == nil {
+		in.End = in.Init
+	}
+
+	tags, err = in.apply(&entry)
+	if err == nil {
+		err = d.AddEntry(&entry, tags)
+	}
+
+	// as stored
+	if err == nil {
+		logger.info.Printf("Entry #%d inserted\n", entry.Id)
+		entry, err = RetrieveEntryByID(d, entry.Id)
+	}
+
+	if err == nil {
+		ae, err = newAPIEntry(d, entry)
+	}
+
+	if err == nil {
+		err = writeJSON(w, http.StatusCreated, ae)
+	}
+
+	return
+}
+
+func apiUpdateEntry(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var tags []string
+	var ae apiEntry
+
+	entry, err := apiEntryByID(d, r)
+	if err != nil {
+		return
+	}
+
+	in, err := readEntryInput(r)
+	if err == nil {
+		tags, err = in.apply(&entry)
+	}
+
+	if err == nil {
+		err = d.inTx(func(dx *Diary) (err error) {
+			err = entry.Update(dx)
+			if err == nil && tags != nil {
+				err = setTags(dx, &entry, tags)
+			}
+
+			return
+		})
+	}
+
+	if err == nil {
+		logger.info.Printf("Entry #%d updated\n", entry.Id)
+		ae, err = newAPIEntry(d, entry)
+	}
+
+	if err == nil {
+		err = writeJSON(w, http.StatusOK, ae)
+	}
+
+	return
+}
+
+func apiDeleteEntry(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	entry, err := apiEntryByID(d, r)
+	if err == nil {
+		_, err = DeleteEntry(d, entry.Id)
+	}
+
+	if err == nil {
+		logger.info.Printf("Entry #%d deleted\n", entry.Id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return
+}
+
+// API_MAX_UPLOAD is the largest request body apiUploadAttachment accepts.
+const API_MAX_UPLOAD = 1 << 30
+
+// apiUploadAttachment stores the request body as an attachment named after
+// ?name=, optionally with ?modified= (RFC 3339). The body is spooled to a
+// temporary file, not kept in memory.
+func apiUploadAttachment(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var attachment Attachment
+	var ae apiEntry
+
+	entry, err := apiEntryByID(d, r)
+	if err != nil {
+		return
+	}
+
+	attachment = Attachment{
+		Name:    filepath.Base(r.URL.Query().Get("name")),
+		EntryId: entry.Id,
+	}
+
+	if attachment.Name == "." || attachment.Name == "/" {
+		return badRequest("name is required")
+	}
+
+	if s := r.URL.Query().Get("modified"); s != "" {
+		attachment.Modified, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return badRequest("modified: %s", err.Error())
+		}
+	}
+
+	fp, err := os.CreateTemp("", "diary_upload_")
+	if err != nil {
+		return
+	}
+
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+
+	_, err = io.Copy(fp, http.MaxBytesReader(w, r.Body, API_MAX_UPLOAD))
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		return apiError{http.StatusRequestEntityTooLarge, fmt.Sprintf("too big: max %s", sizeNorm(mbe.Limit))}
+	}
+
+	if err == nil {
+		_, err = fp.Seek(0, io.SeekStart)
+	}
+
+	if err == nil {
+		err = attachment.InsertFrom(d, fp)
+	}
+
+	if err == nil {
+		logger.info.Printf("Attached %s to entry #%d\n", attachment.Name, entry.Id)
+		ae, err = newAPIEntry(d, entry)
+	}
+
+	if err == nil {
+		err = writeJSON(w, http.StatusCreated, ae)
+	}
+
+	return
+}
+
+func apiDownloadAttachment(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	return serveAttachment(d, w, r, r.PathValue("id"), nil)
+}
+
+func apiDeleteAttachment(d *Diary, w http.ResponseWriter, r *http.Request) (err error) {
+	var a Attachment
+
+	id, err := pathId(r)
+	if err == nil {
+		a, err = RetrieveAttachmentByIDNC(d, id)
+	}
+
+	if err == nil && a.Deleted {
+		err = NOT_FOUND
+	}
+
+	if err == nil {
+		_, err = DeleteAttachment(d, id)
+	}
+
+	if err == nil {
+		logger.info.Printf("Attachment #%d deleted\n", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return
+}
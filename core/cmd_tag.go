@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func cmdTag(d *Diary) (err error) {
+	if args.Id <= 0 {
+		err = errors.New("you must specify an id")
+		return
+	}
+
+	entry, err := d.Tag(args.Id, args.Tags)
+	if err == nil {
+		logger.info.Printf("Entry #%d tags: %s\n", entry.Id, strings.Join(entry.Tags, ", "))
+	}
+
+	return
+}
+
+func cmdUntag(d *Diary) (err error) {
+	if args.Id <= 0 {
+		err = errors.New("you must specify an id")
+		return
+	}
+
+	entry, aff, err := d.Untag(args.Id, args.Tags)
+	if err == nil {
+		logger.info.Printf("%d tag(s) removed, entry #%d tags: %s\n", aff, entry.Id, strings.Join(entry.Tags, ", "))
+	}
+
+	return
+}
+
+// Tag adds tags to an entry, returned with all its tags.
+func (d *Diary) Tag(id int64, tags []string) (e Entry, err error) {
+	err = d.inTx(func(dx *Diary) (err error) {
+		e, err = retrieveEntryForTagging(dx, id, tags)
+		if err == nil {
+			err = e.AddTags(dx, tags)
+		}
+
+		return
+	})
+
+	return
+}
+
+// Untag removes tags from an entry, returned with the tags left, and returns
+// how many it had.
+func (d *Diary) Untag(id int64, tags []string) (e Entry, aff int64, err error) {
+	err = d.inTx(func(dx *Diary) (err error) {
+		e, err = retrieveEntryForTagging(dx, id, tags)
+		if err == nil {
+			aff, err = e.RemoveTags(dx, tags)
+		}
+
+		return
+	})
+
+	return
+}
+
+func retrieveEntryForTagging(d *Diary, id int64, tags []string) (entry Entry, err error) {
+	if len(tags) == 0 {
+		err = errors.New("you must specify at least one tag")
+		return
+	}
+
+	entry, err = RetrieveEntryByID(d, id)
+	if err == NOT_FOUND {
+		err = fmt.Errorf("entry #%d not found", id)
+	}
+
+	return
+}
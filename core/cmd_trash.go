@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func formatDeletedAt(deletedAt time.Time) string {
+	if deletedAt.IsZero() {
+		return "unknown"
+	}
+
+	return deletedAt.Format(time.DateTime)
+}
+
+func cmdTrash(d *Diary) (err error) {
+	entries, attachments, err := d.Trash()
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Deleted entries:")
+	for _, ex := range entries {
+		note, _, _ := strings.Cut(ex.Note, "\n")
+		fmt.Printf("[%d] %s (deleted %s) %s\n", ex.Id, d.formatTime(ex.Init), formatDeletedAt(ex.DeletedAt), note)
+	}
+
+	fmt.Println("Deleted attachments:")
+	for _, ax := range attachments {
+		fmt.Printf("[%d] %s (%s, entry #%d, deleted %s)\n", ax.Id, ax.Name, sizeNorm(ax.Size), ax.EntryId, formatDeletedAt(ax.DeletedAt))
+	}
+
+	return
+}
+
+// Trash returns the deleted entries and attachments, without content, that
+// can still be restored.
+func (d *Diary) Trash() (entries []Entry, attachments []Attachment, err error) {
+	rows, err := d.db.Query(QUERY_ENTRY_ALL + " where deleted = 1 order by id")
+	if err != nil {
+		return
+	}
+
+	for rows.Next() && err == nil {
+		var entry Entry
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	rows.Close()
+
+	if err != nil {
+		return
+	}
+
+	rows, err = d.db.Query(QUERY_ATTACHMENT_NC + " where a.deleted = 1 order by a.id")
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var attachment Attachment
+
+		attachment, err = CreateAttachmentByScanNC(d, rows)
+		if err == nil {
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+func cmdRestore(d *Diary) (err error) {
+	var what = "entry"
+
+	if args.Id < 0 {
+		err = errors.New("invalid id")
+		return
+	}
+
+	if args.Attachment {
+		what = "attachment"
+		err = d.RestoreAttachment(args.Id)
+	} else {
+		err = d.Restore(args.Id)
+	}
+
+	if err == nil {
+		logger.info.Printf("%s #%d restored\n", what, args.Id)
+	}
+
+	return
+}
+
+// Restore undeletes an entry, and records it in the anomalies table.
+func (d *Diary) Restore(id int64) error {
+	return d.restore("entry", id, RestoreEntry)
+}
+
+// RestoreAttachment is Restore for attachments.
+func (d *Diary) RestoreAttachment(id int64) error {
+	return d.restore("attachment", id, RestoreAttachment)
+}
+
+func (d *Diary) restore(what string, id int64, restore func(d *Diary, id int64) (int64, error)) error {
+	return d.inTx(func(dx *Diary) (err error) {
+		aff, err := restore(dx, id)
+		if err == nil && aff == 0 {
+			err = fmt.Errorf("deleted %s #%d not found", what, id)
+		}
+
+		if err == nil {
+			err = logAnomaly(dx, fmt.Sprintf("restore: %s #%d", what, id))
+		}
+
+		return
+	})
+}
+
+// rows with no deletion time (see 0009_deleted_at_backfill) are deleted now
+const purgeCondition = "deleted = 1 and coalesce(deleted_at, unixepoch()) <= ?"
+
+// PurgeStats counts what Purge deletes, or would delete.
+type PurgeStats struct {
+	Entries     int64
+	Attachments int64
+	Orphans     int64 // attachments, not deleted, of purged entries
+	Blobs       int64 // contents no longer used, unknown on dry runs
+}
+
+func cmdPurge(d *Diary) (err error) {
+	stats, err := d.Purge(args.Retention, true)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Rows deleted more than %d day(s) ago:\n", args.Retention)
+	fmt.Printf("Entries:              %d\n", stats.Entries)
+	fmt.Printf("Attachments:          %d\n", stats.Attachments)
+	fmt.Printf("Orphaned attachments: %d\n", stats.Orphans)
+
+	if args.DryRun || stats.Entries+stats.Attachments+stats.Orphans == 0 {
+		return
+	}
+
+	if !args.Force && !confirm("Permanently delete them?") {
+		return
+	}
+
+	stats, err = d.Purge(args.Retention, false)
+	if err == nil {
+		logger.info.Printf("%d unused blob(s) deleted\n", stats.Blobs)
+	}
+
+	return
+}
+
+// Purge permanently deletes the rows deleted more than retention days ago,
+// along with the attachments of purged entries and the contents no longer
+// used, then compacts the database. If dryRun is set, rows are only counted.
+func (d *Diary) Purge(retention int, dryRun bool) (stats PurgeStats, err error) {
+	if retention < 0 {
+		err = errors.New("invalid retention")
+		return
+	}
+
+	limit := time.Now().AddDate(0, 0, -retention).Unix()
+
+	err = d.inTx(func(dx *Diary) (err error) {
+		err = dx.db.QueryRow("select count(*) from entries where "+purgeCondition, limit).Scan(&stats.Entries)
+		if err == nil {
+			err = dx.db.QueryRow("select count(*) from attachments where "+purgeCondition, limit).Scan(&stats.Attachments)
+		}
+		if err == nil {
+			err = dx.db.QueryRow("select count(*) from attachments where not ("+purgeCondition+") and entry_id not in (select id from entries where not ("+purgeCondition+"))", limit, limit).Scan(&stats.Orphans)
+		}
+
+		if err != nil || dryRun || stats.Entries+stats.Attachments+stats.Orphans == 0 {
+			return
+		}
+
+		for _, qx := range []struct {
+			query  string
+			params []any
+		}{
+			{"delete from attachments where " + purgeCondition, []any{limit}},
+			{"delete from entries where " + purgeCondition, []any{limit}},
+			{"delete from attachments where entry_id not in (select id from entries)", nil},
+			{"delete from entry_tags where entry_id not in (select id from entries)", nil},
+		} {
+			_, err = dx.db.Exec(qx.query, qx.params...)
+			if err != nil {
+				return
+			}
+		}
+
+		stats.Blobs, err = deleteOrphanBlobs(dx.db)
+		if err == nil {
+			err = logAnomaly(dx, fmt.Sprintf("purge: %d entries, %d attachments, %d orphaned attachments (retention %d days)", stats.Entries, stats.Attachments, stats.Orphans, retention))
+		}
+
+		return
+	})
+
+	if err == nil && !dryRun && stats.Entries+stats.Attachments+stats.Orphans > 0 {
+		d.logf("Purged, vacuuming\n")
+		_, err = d.db.Exec("VACUUM")
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Codecs for stored contents. Contents are compressed before being sealed.
+const (
+	CODEC_NONE  = ""
+	CODEC_FLATE = "flate"
+	CODEC_GZIP  = "gzip"
+)
+
+func validCodec(codec string) bool {
+	switch codec {
+	case CODEC_NONE, CODEC_FLATE, CODEC_GZIP:
+		return true
+	}
+
+	return false
+}
+
+// compress compresses plain with codec. If that does not actually shrink it,
+// plain is returned as it is, with CODEC_NONE.
+func compress(plain []byte, codec string) (data []byte, used string, err error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch codec {
+	case CODEC_NONE:
+		return plain, CODEC_NONE, nil
+	case CODEC_FLATE:
+		w, err = flate.NewWriter(&buf, flate.BestCompression)
+	case CODEC_GZIP:
+		w, err = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	default:
+		err = fmt.Errorf("unknown codec: %s", codec)
+	}
+
+	if err == nil {
+		_, err = w.Write(plain)
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	if err != nil || buf.Len() >= len(plain) {
+		return plain, CODEC_NONE, err
+	}
+
+	return buf.Bytes(), codec, nil
+}
+
+func decompress(data []byte, codec string) (plain []byte, err error) {
+	var buf bytes.Buffer
+	var r io.ReadCloser
+
+	switch codec {
+	case CODEC_NONE:
+		return data, nil
+	case CODEC_FLATE:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CODEC_GZIP:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	default:
+		err = fmt.Errorf("unknown codec: %s", codec)
+	}
+
+	if err != nil {
+		return
+	}
+
+	defer r.Close()
+	_, err = buf.ReadFrom(r)
+
+	return buf.Bytes(), err
+}
+
+// packContent prepares plain, a chunk of a content, to be stored: compressed
+// with d.Codec, when worth it, and sealed.
+func (d *Diary) packContent(plain []byte) (data []byte, codec string, err error) {
+	data, codec, err = compress(plain, d.Codec)
+	if err == nil {
+		data = d.seal(data)
+	}
+
+	return
+}
+
+// unpackContent reverses packContent.
+func (d *Diary) unpackContent(data []byte, codec string) (plain []byte, err error) {
+	plain, err = d.unseal(data)
+	if err == nil && plain != nil {
+		plain, err = decompress(plain, codec)
+	}
+
+	return
+}
@@ -0,0 +1,408 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A command of the command line: diary NAME [flags] [ARGS]
+type command struct {
+	name    string
+	args    string // positional arguments, as shown in the usage
+	summary string
+	hidden  bool // not listed, kept for old scripts
+
+	// flags defines the flags of the command on f, bound to args
+	flags func(f *flag.FlagSet)
+
+	// positional sets args from the positional arguments and validates them
+	positional func(pos []string) error
+
+	run func(d *Diary) error
+
+	noMigrate bool // migrate shows what is being applied
+	noUnlock  bool
+	ownOutput bool // -output is written by the command, not opened for it
+}
+
+var commands = []command{
+	{name: "add", summary: "add an entry, the editor is opened unless -note is given",
+		flags: flags(flagNote, flagDates, flagTags, flagNoAttach, flagCodec), run: cmdAdd},
+	{name: "add-attach", args: "ID", summary: "add attachments to an entry",
+		flags: flags(flagCodec), positional: positionalId, run: cmdAddAttach},
+	{name: "edit", args: "ID", summary: "edit an entry, the editor is opened unless -note is given",
+		flags: flags(flagNote, flagDates), positional: positionalId, run: cmdEdit},
+	{name: "tag", args: "ID TAG...", summary: "add tags to an entry",
+		positional: positionalIdTags, run: cmdTag},
+	{name: "untag", args: "ID TAG...", summary: "remove tags from an entry",
+		positional: positionalIdTags, run: cmdUntag},
+	{name: "resume", summary: "show the entries of a day, or of a range of days",
+		flags: flags(flagRange, flagSpans, flagTags, flagZone), run: cmdResume},
+	{name: "search", args: "QUERY", summary: "full-text search over notes and attachment names",
+		positional: positionalQuery, run: cmdSearch},
+	{name: "delete", hidden: true, summary: "replaced by delete-entry and delete-attachment", run: cmdDelete},
+	{name: "delete-entry", args: "ID", summary: "delete an entry and its attachments",
+		flags: flags(flagForce), positional: positionalId, run: cmdDeleteEntry},
+	{name: "delete-attachment", args: "ID", summary: "delete an attachment",
+		flags: flags(flagForce), positional: positionalId, run: cmdDeleteAttachment},
+	{name: "trash", summary: "list deleted entries and attachments", run: cmdTrash},
+	{name: "restore", args: "ID", summary: "restore a deleted entry, or attachment",
+		flags: flags(flagAttachment), positional: positionalId, run: cmdRestore},
+	{name: "purge", summary: "permanently delete what has been deleted for a while",
+		flags: flags(flagRetention, flagDryRun, flagForce), run: cmdPurge},
+	{name: "fetch", args: "ID", summary: "write the content of an attachment",
+		flags: flags(flagOutput, flagOperm, flagMtime), positional: positionalId, run: cmdFetch},
+	{name: "dump-day", summary: "write the HTML page of a day, and its attachments",
+		flags: flags(flagFrom, flagTags, flagFormat, flagTemplates, flagOperm, flagZone), run: cmdDumpDay},
+	{name: "dump", summary: "write the diary as a simple website",
+		flags: flags(flagTags, flagFormat, flagTemplates, flagOperm, flagForce, flagZone), run: cmdDump},
+	{name: "serve", summary: "serve the pages of dump over HTTP",
+		flags: flags(flagAddr, flagTags, flagFormat, flagTemplates, flagZone), run: cmdServe},
+	{name: "serve-api", summary: "serve a JSON API over HTTP",
+		flags: flags(flagAddr, flagTLS, flagZone), run: cmdServeAPI},
+	{name: "export-json", summary: "export the whole diary as JSON",
+		flags: flags(flagOutput, flagOperm), run: cmdExportJSON},
+	{name: "import-json", args: "FILE", summary: "import a JSON export",
+		flags: flags(flagMerge), positional: positionalInput, run: cmdImportJSON},
+	{name: "dedup", summary: "store attachment contents once",
+		flags: flags(flagDryRun), run: cmdDedup},
+	{name: "compact", summary: "recompress attachment contents",
+		flags: flags(flagCodec, flagDryRun), run: cmdCompact},
+	{name: "backup", summary: "copy the diary safely, even while it is in use",
+		flags: flags(flagOutput, flagOperm, flagBackup), run: cmdBackup, noMigrate: true, noUnlock: true, ownOutput: true},
+	{name: "doctor", summary: "check the database for inconsistencies",
+		flags: flags(flagFix), run: cmdDoctor},
+	{name: "migrate", summary: "bring the database schema up to date",
+		flags: flags(flagDryRun), run: cmdMigrate, noMigrate: true, noUnlock: true},
+	{name: "encrypt", summary: "encrypt the diary with a passphrase", run: cmdEncrypt},
+	{name: "rekey", summary: "change the passphrase", run: cmdRekey},
+	{name: "info", summary: "show statistics about the database", run: cmdInfo},
+	{name: "license", summary: "show the license", run: cmdLicense, noUnlock: true},
+}
+
+func lookupCommand(name string) (cmd command, ok bool) {
+	name = strings.ToLower(name)
+
+	for _, cx := range commands {
+		if cx.name == name {
+			return cx, true
+		}
+	}
+
+	return
+}
+
+func flags(defs ...func(f *flag.FlagSet)) func(f *flag.FlagSet) {
+	return func(f *flag.FlagSet) {
+		for _, def := range defs {
+			def(f)
+		}
+	}
+}
+
+// Flags are defined with the current value of args as default, see
+// defaultArgs.
+
+func flagCommon(f *flag.FlagSet) {
+	f.StringVar(&args.Path, "path", args.Path, "diary file path")
+	f.StringVar(&args.WorkDir, "wd", "", "working directory")
+	f.BoolVar(&args.Verbose, "v", false, "verbose info")
+}
+
+func flagNote(f *flag.FlagSet) {
+	f.StringVar(&args.Note, "note", "", "note to log into the diary")
+}
+
+func flagDates(f *flag.FlagSet) {
+	f.StringVar(&args.DateInitStr, "di", "", "init date: 2025-06-24, yesterday, -3d, last monday... (default today)")
+	f.StringVar(&args.TimeInitStr, "ti", "", "init time: 14:30, 2pm... (default now)")
+	f.StringVar(&args.DateEndStr, "de", "", "end date, if empty it's set equal to the init date")
+	f.StringVar(&args.TimeEndStr, "te", "", "end time, if empty it's set equal to the init time")
+}
+
+// flagFrom is the date of commands showing a day.
+func flagFrom(f *flag.FlagSet) {
+	f.StringVar(&args.DateInitStr, "di", "", "date: 2025-06-24, yesterday, -3d, last monday... (default today)")
+	f.StringVar(&args.DateInitStr, "from", "", "same as -di")
+}
+
+// flagRange are the days of commands showing a range of days.
+func flagRange(f *flag.FlagSet) {
+	flagFrom(f)
+	f.StringVar(&args.DateEndStr, "de", "", "end date, included; if empty it's set equal to the init date")
+	f.StringVar(&args.DateEndStr, "to", "", "same as -de")
+}
+
+func flagSpans(f *flag.FlagSet) {
+	f.BoolVar(&args.Week, "week", false, "the week (Monday to Sunday) of the init date")
+	f.BoolVar(&args.Month, "month", false, "the month of the init date")
+	f.StringVar(&args.Last, "last", "", "the last days (7d), weeks (2w) or months (1m), up to the init date")
+}
+
+func flagTags(f *flag.FlagSet) {
+	f.Var(&args.Tags, "tag", "tag (repeatable)")
+}
+
+func flagNoAttach(f *flag.FlagSet) {
+	f.BoolVar(&args.NoAttach, "na", false, "do not ask for attachments")
+}
+
+func flagCodec(f *flag.FlagSet) {
+	f.StringVar(&args.Codec, "codec", args.Codec, "compression for attachments (flate, gzip, none)")
+}
+
+func flagFormat(f *flag.FlagSet) {
+	f.StringVar(&args.Format, "format", args.Format, "note format (markdown, plain)")
+}
+
+func flagTemplates(f *flag.FlagSet) {
+	f.StringVar(&args.TemplateDir, "templates", "", "directory with templates overriding the default ones")
+}
+
+func flagOperm(f *flag.FlagSet) {
+	f.StringVar(&args.OutputPermStr, "operm", args.OutputPermStr, "permission of written files")
+}
+
+func flagOutput(f *flag.FlagSet) {
+	f.StringVar(&args.OutputFileStr, "output", "", "output file path, - for stdout")
+	f.StringVar(&args.OutputFileStr, "o", "", "same as -output")
+}
+
+func flagMtime(f *flag.FlagSet) {
+	f.BoolVar(&args.Mtime, "mtime", false, "restore the modification time of the attached file")
+}
+
+func flagForce(f *flag.FlagSet) {
+	f.BoolVar(&args.Force, "f", false, "force, do not ask for confirmation")
+}
+
+func flagDryRun(f *flag.FlagSet) {
+	f.BoolVar(&args.DryRun, "dry", false, "dry run")
+}
+
+func flagAttachment(f *flag.FlagSet) {
+	f.BoolVar(&args.Attachment, "attachment", false, "the id refers to an attachment")
+}
+
+func flagRetention(f *flag.FlagSet) {
+	f.IntVar(&args.Retention, "days", args.Retention, "retention period in days")
+}
+
+func flagMerge(f *flag.FlagSet) {
+	f.BoolVar(&args.Merge, "merge", false, "skip duplicates")
+}
+
+func flagBackup(f *flag.FlagSet) {
+	f.IntVar(&args.Keep, "keep", 0, "previous backups to keep, numbered")
+	f.BoolVar(&args.Check, "check", false, "check the integrity of the backup")
+}
+
+func flagFix(f *flag.FlagSet) {
+	f.BoolVar(&args.Fix, "fix", false, "repair what can be safely repaired")
+}
+
+func flagAddr(f *flag.FlagSet) {
+	f.StringVar(&args.Addr, "addr", args.Addr, "address to serve on")
+}
+
+func flagZone(f *flag.FlagSet) {
+	f.StringVar(&args.ZoneStr, "zone", args.ZoneStr, "zone days begin and end in, e.g. Europe/Rome (default: local zone)")
+}
+
+func flagTLS(f *flag.FlagSet) {
+	f.StringVar(&args.CertFile, "cert", "", "TLS certificate file (PEM)")
+	f.StringVar(&args.KeyFile, "key", "", "TLS private key file (PEM)")
+}
+
+func positionalId(pos []string) (err error) {
+	if len(pos) != 1 {
+		return fmt.Errorf("expected an id")
+	}
+
+	args.Id, err = strconv.ParseInt(pos[0], 10, 64)
+	if err != nil || args.Id <= 0 {
+		err = fmt.Errorf("invalid id: %s", pos[0])
+	}
+
+	return
+}
+
+func positionalIdTags(pos []string) (err error) {
+	if len(pos) < 2 {
+		return fmt.Errorf("expected an id and at least a tag")
+	}
+
+	err = positionalId(pos[:1])
+
+	for _, tx := range pos[1:] {
+		if err != nil {
+			break
+		}
+
+		err = args.Tags.Set(tx)
+	}
+
+	return
+}
+
+func positionalQuery(pos []string) error {
+	args.Query = strings.Join(pos, " ")
+
+	if strings.TrimSpace(args.Query) == "" {
+		return fmt.Errorf("expected a query")
+	}
+
+	return nil
+}
+
+func positionalInput(pos []string) error {
+	if len(pos) != 1 {
+		return fmt.Errorf("expected a file")
+	}
+
+	args.InputFileStr = pos[0]
+	return nil
+}
+
+// parseCommand parses diary NAME [flags] [ARGS]: flags and positional
+// arguments can be mixed, as in diary fetch 12 -o file.
+func parseCommand(argv []string) (err error) {
+	cmd, ok := lookupCommand(argv[0])
+	if !ok {
+		if strings.ToLower(argv[0]) == "help" {
+			return parseHelp(argv[1:])
+		}
+
+		return fmt.Errorf("invalid command: %s (see diary help)", argv[0])
+	}
+
+	args.Command = cmd.name
+
+	f := commandFlagSet(cmd)
+
+	pos, err := parseInterspersed(f, argv[1:])
+	if err == flag.ErrHelp {
+		args.Help = true
+		args.Usage = commandUsage(cmd)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s (see diary help %s)", cmd.name, err.Error(), cmd.name)
+	}
+
+	visitSet(f)
+
+	if cmd.positional != nil {
+		err = cmd.positional(pos)
+	} else if len(pos) > 0 {
+		err = fmt.Errorf("unexpected argument %s", pos[0])
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%s: %s (see diary help %s)", cmd.name, err.Error(), cmd.name)
+	}
+
+	return
+}
+
+// parseHelp handles diary help [COMMAND].
+func parseHelp(argv []string) error {
+	args.Help = true
+
+	if len(argv) == 0 {
+		args.Usage = commandsUsage()
+		return nil
+	}
+
+	cmd, ok := lookupCommand(argv[0])
+	if !ok {
+		return fmt.Errorf("invalid command: %s", argv[0])
+	}
+
+	args.Usage = commandUsage(cmd)
+	return nil
+}
+
+func commandFlagSet(cmd command) (f *flag.FlagSet) {
+	f = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+
+	flagCommon(f)
+	if cmd.flags != nil {
+		cmd.flags(f)
+	}
+
+	return
+}
+
+// parseInterspersed parses f from argv, returning the arguments that are not
+// flags, wherever they are. Everything after -- is not a flag.
+func parseInterspersed(f *flag.FlagSet, argv []string) (pos []string, err error) {
+	for len(argv) > 0 {
+		var rest []string
+
+		err = f.Parse(argv)
+		if err != nil {
+			return
+		}
+
+		rest = f.Args()
+		if len(rest) == 0 {
+			break
+		}
+
+		// Parse stops at the first non-flag, or after --
+		if len(rest) < len(argv) && argv[len(argv)-len(rest)-1] == "--" {
+			return append(pos, rest...), nil
+		}
+
+		pos = append(pos, rest[0])
+		argv = rest[1:]
+	}
+
+	return
+}
+
+func commandUsage(cmd command) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Usage: diary %s [flags]", cmd.name)
+	if cmd.args != "" {
+		fmt.Fprintf(&sb, " %s", cmd.args)
+	}
+
+	if cmd.summary != "" {
+		fmt.Fprintf(&sb, "\n\n%s.", strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+	}
+
+	fmt.Fprintf(&sb, "\n\nFlags:\n")
+
+	f := commandFlagSet(cmd)
+	f.SetOutput(&sb)
+	f.PrintDefaults()
+
+	fmt.Fprintf(&sb, "\nSee diary -help for details.\n")
+
+	return sb.String()
+}
+
+func commandsUsage() string {
+	var sb strings.Builder
+
+	sb.WriteString("Usage: diary COMMAND [flags] [ARGS]\n\nCommands:\n")
+
+	for _, cx := range commands {
+		if !cx.hidden {
+			fmt.Fprintf(&sb, "    %-18s %s\n", cx.name, cx.summary)
+		}
+	}
+
+	sb.WriteString("\nRun diary help COMMAND for the flags of a command, diary -help for details.\n")
+
+	return sb.String()
+}
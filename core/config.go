@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Defaults read from $XDG_CONFIG_HOME/diary/config.toml (or config.json).
+// Flags take precedence over environment variables, which take precedence
+// over the configuration.
+type config struct {
+	Path   string   `json:"path"`
+	Editor string   `json:"editor"`
+	Operm  string   `json:"operm"`
+	Tags   []string `json:"tags"`
+	Zone   string   `json:"zone"`
+}
+
+var configNames = []string{"config.toml", "config.json"}
+
+// loadConfig returns an empty configuration if there is no file.
+func loadConfig() (c config, path string, err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return c, "", nil
+	}
+
+	for _, name := range configNames {
+		var data []byte
+
+		path = filepath.Join(dir, "diary", name)
+
+		data, err = os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err == nil {
+			if filepath.Ext(name) == ".json" {
+				err = json.Unmarshal(data, &c)
+			} else {
+				err = parseTOMLConfig(string(data), &c)
+			}
+		}
+
+		if err != nil {
+			err = fmt.Errorf("%s: %s", path, err.Error())
+		}
+
+		return
+	}
+
+	return c, "", nil
+}
+
+// parseTOMLConfig supports what a flat configuration needs: key = value
+// pairs, with strings, integers and arrays of strings, and comments.
+func parseTOMLConfig(data string, c *config) (err error) {
+	for i, line := range strings.Split(data, "\n") {
+		var key, value string
+		var found bool
+
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		key, value, found = strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("line %d: expected key = value", i+1)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "path":
+			c.Path, err = tomlSingleString(value)
+		case "editor":
+			c.Editor, err = tomlSingleString(value)
+		case "zone":
+			c.Zone, err = tomlSingleString(value)
+		case "operm":
+			c.Operm, err = tomlSingleString(value)
+
+			// also as an integer: 640
+			if err != nil {
+				number, _, _ := strings.Cut(value, "#")
+				number = strings.TrimSpace(number)
+
+				if _, errN := strconv.Atoi(number); errN == nil {
+					c.Operm, err = number, nil
+				}
+			}
+		case "tags":
+			c.Tags, err = tomlStringArray(value)
+		default:
+			err = fmt.Errorf("unknown key %s", key)
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+	}
+
+	return
+}
+
+func tomlSingleString(value string) (s string, err error) {
+	s, rest, err := tomlString(value)
+	if err == nil {
+		err = tomlEnd(rest)
+	}
+
+	return
+}
+
+// tomlStringArray parses ["...", '...'] on a single line.
+func tomlStringArray(value string) (list []string, err error) {
+	if value == "" || value[0] != '[' {
+		return nil, fmt.Errorf("expected an array")
+	}
+
+	list = []string{}
+	value = strings.TrimSpace(value[1:])
+
+	for {
+		var s string
+
+		if value != "" && value[0] == ']' {
+			return list, tomlEnd(value[1:])
+		}
+
+		s, value, err = tomlString(value)
+		if err != nil {
+			return
+		}
+
+		list = append(list, s)
+		value = strings.TrimSpace(value)
+
+		if value != "" && value[0] == ',' {
+			value = strings.TrimSpace(value[1:])
+		} else if value == "" || value[0] != ']' {
+			return nil, fmt.Errorf("unterminated array")
+		}
+	}
+}
+
+// tomlEnd checks nothing but a comment follows a value.
+func tomlEnd(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && rest[0] != '#' {
+		return fmt.Errorf("unexpected %s", rest)
+	}
+
+	return nil
+}
+
+// tomlString parses a basic ("...") or literal ('...') string at the start
+// of value, and returns what follows it.
+func tomlString(value string) (s string, rest string, err error) {
+	if value == "" {
+		return "", "", fmt.Errorf("expected a string")
+	}
+
+	switch value[0] {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+
+		return value[1 : end+1], value[end+2:], nil
+
+	case '"':
+		for end := 1; end < len(value); end++ {
+			switch value[end] {
+			case '\\':
+				end++
+			case '"':
+				s, err = strconv.Unquote(value[:end+1])
+				return s, value[end+1:], err
+			}
+		}
+
+		return "", "", fmt.Errorf("unterminated string")
+	}
+
+	return "", "", fmt.Errorf("expected a string")
+}
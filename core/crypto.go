@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Encrypted diaries store notes and attachment contents sealed with a random
+// data key (AES-256-GCM, nonce prepended to the ciphertext).
+// The data key is stored in the metadata table, sealed with a key derived from
+// the passphrase: changing the passphrase does not require to re-encrypt data.
+
+const (
+	KDF_NAME       = "pbkdf2-sha256"
+	KDF_ITERATIONS = 600000
+	KDF_SALT_SIZE  = 16
+	DATA_KEY_SIZE  = 32
+)
+
+var WRONG_PASSPHRASE = errors.New("wrong passphrase")
+
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err == nil {
+		aead, err = cipher.NewGCM(block)
+	}
+
+	return
+}
+
+func sealWith(aead cipher.AEAD, plain []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	rand.Read(nonce)
+
+	return aead.Seal(nonce, nonce, plain, nil)
+}
+
+func openWith(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// seal encrypts data if the diary is encrypted, otherwise data is returned
+// as it is.
+func (d *Diary) seal(plain []byte) []byte {
+	if d.aead == nil {
+		return plain
+	}
+
+	return sealWith(d.aead, plain)
+}
+
+func (d *Diary) unseal(data []byte) ([]byte, error) {
+	if d.aead == nil || data == nil {
+		return data, nil
+	}
+
+	return openWith(d.aead, data)
+}
+
+// sealString is meant for TEXT columns: plain text is stored as text.
+func (d *Diary) sealString(plain string) any {
+	if d.aead == nil {
+		return plain
+	}
+
+	return sealWith(d.aead, []byte(plain))
+}
+
+func (d *Diary) unsealString(data []byte) (plain string, err error) {
+	buf, err := d.unseal(data)
+	plain = string(buf)
+	return
+}
+
+func getMetadata(d *Diary, key string) (value []byte, err error) {
+	err = d.db.QueryRow("select value from metadata where key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		err = NOT_FOUND
+	}
+
+	return
+}
+
+func setMetadata(tx *sql.Tx, key string, value any) (err error) {
+	_, err = tx.Exec("insert or replace into metadata (key, value) values (?, ?)", key, value)
+	return
+}
+
+// IsEncrypted tells whether the diary must be unlocked.
+func (d *Diary) IsEncrypted() (bool, error) {
+	_, err := getMetadata(d, "data_key")
+
+	if err == NOT_FOUND {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func deriveKey(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, DATA_KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAEAD(key)
+}
+
+// readPassphrase reads a line from stdin, hiding the input if stdin is a
+// terminal.
+func readPassphrase(prompt string) (passphrase string, err error) {
+	var sb strings.Builder
+	var ch = make([]byte, 1)
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	stty := exec.Command("stty", "-echo")
+	stty.Stdin = os.Stdin
+	hidden := stty.Run() == nil
+
+	// byte by byte, not to consume input meant for someone else
+	for {
+		var n int
+
+		n, err = os.Stdin.Read(ch)
+		if err != nil || n == 0 || ch[0] == '\n' {
+			break
+		}
+
+		sb.WriteByte(ch[0])
+	}
+
+	if hidden {
+		stty = exec.Command("stty", "echo")
+		stty.Stdin = os.Stdin
+		stty.Run()
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if err != nil && sb.Len() > 0 {
+		err = nil
+	}
+
+	passphrase = strings.TrimSuffix(sb.String(), "\r")
+	return
+}
+
+func readNewPassphrase() (passphrase string, err error) {
+	passphrase, err = readPassphrase("New passphrase: ")
+	if err != nil {
+		return
+	}
+
+	if passphrase == "" {
+		err = errors.New("empty passphrase")
+		return
+	}
+
+	again, err := readPassphrase("Repeat new passphrase: ")
+	if err == nil && again != passphrase {
+		err = errors.New("passphrases do not match")
+	}
+
+	return
+}
+
+// Unlock loads the data key of an encrypted diary, sealed with a key derived
+// from passphrase, then converts contents stored by older versions (see
+// convertInline). It does nothing if the diary is not encrypted.
+func (d *Diary) Unlock(passphrase string) (err error) {
+	var salt, iterations, wrapped, key []byte
+	var kek cipher.AEAD
+
+	encrypted, err := d.IsEncrypted()
+	if err != nil || !encrypted {
+		return
+	}
+
+	salt, err = getMetadata(d, "kdf_salt")
+	if err == nil {
+		iterations, err = getMetadata(d, "kdf_iterations")
+	}
+	if err == nil {
+		wrapped, err = getMetadata(d, "data_key")
+	}
+	if err != nil {
+		return fmt.Errorf("encryption metadata: %s", err.Error())
+	}
+
+	iter, err := strconv.Atoi(string(iterations))
+	if err != nil {
+		return fmt.Errorf("encryption metadata: %s", err.Error())
+	}
+
+	kek, err = deriveKey(passphrase, salt, iter)
+	if err == nil {
+		key, err = openWith(kek, wrapped)
+		if err != nil {
+			return WRONG_PASSPHRASE
+		}
+
+		err = d.setDataKey(key)
+	}
+
+	if err == nil {
+		_, _, err = convertInline(d)
+	}
+
+	return
+}
+
+func (d *Diary) setDataKey(key []byte) (err error) {
+	aead, err := newAEAD(key)
+	if err == nil {
+		d.key, d.aead = key, aead
+	}
+
+	return
+}
+
+// unlock asks for the passphrase, if the diary is encrypted, and loads the
+// data key. The passphrase can be given through DIARY_PASSPHRASE.
+func unlock(d *Diary) (err error) {
+	encrypted, err := d.IsEncrypted()
+	if err != nil || !encrypted {
+		return
+	}
+
+	passphrase, isSet := os.LookupEnv("DIARY_PASSPHRASE")
+	if !isSet {
+		passphrase, err = readPassphrase("Passphrase: ")
+		if err != nil {
+			return
+		}
+	}
+
+	return d.Unlock(passphrase)
+}
+
+// storeDataKey seals key with a key derived from passphrase and stores it,
+// along with the KDF parameters.
+func storeDataKey(tx *sql.Tx, passphrase string, key []byte) (err error) {
+	var salt = make([]byte, KDF_SALT_SIZE)
+	var kek cipher.AEAD
+
+	rand.Read(salt)
+
+	kek, err = deriveKey(passphrase, salt, KDF_ITERATIONS)
+	if err != nil {
+		return
+	}
+
+	for _, kv := range [][2]any{
+		{"kdf", KDF_NAME},
+		{"kdf_iterations", strconv.Itoa(KDF_ITERATIONS)},
+		{"kdf_salt", salt},
+		{"data_key", sealWith(kek, key)},
+	} {
+		err = setMetadata(tx, kv[0].(string), kv[1])
+		if err != nil {
+			break
+		}
+	}
+
+	return
+}
@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dates and times of flags are parsed relative to now. Dates:
+//
+//	2025-06-24
+//	today, yesterday, tomorrow
+//	-3d, +1w, -2m, -1y      days, weeks, months, years from today
+//	monday, last monday     the last Monday, today included or not
+//	next fri                the next Friday, today not included
+//	2025-06-24T14:30:00+02:00, 2025-06-24 14:30    ISO 8601, with the time
+//
+// Times:
+//
+//	14:30, 14:30:05, 14
+//	2pm, 2:30pm, 12am
+//	now, noon, midnight
+
+var isoDateTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	time.DateTime,
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04Z07:00",
+}
+
+// parseDateTime parses a date and a time, see above. If date is a datetime,
+// timeStr must be empty. An empty date is today, an empty time is the time of
+// day of clock.
+func parseDateTime(dateStr string, timeStr string, now time.Time, clock time.Time) (t time.Time, err error) {
+	date, hasTime, err := parseDate(dateStr, now)
+	if err != nil {
+		return
+	}
+
+	if hasTime {
+		if strings.TrimSpace(timeStr) != "" {
+			err = fmt.Errorf("%s already has a time", dateStr)
+		}
+
+		return date, err
+	}
+
+	return setClock(date, timeStr, now, clock)
+}
+
+// setClock sets the time of day of date, keeping its location.
+func setClock(date time.Time, timeStr string, now time.Time, clock time.Time) (t time.Time, err error) {
+	h, m, s, err := parseClock(timeStr, now, clock)
+	if err == nil {
+		t = time.Date(date.Year(), date.Month(), date.Day(), h, m, s, 0, date.Location())
+	}
+
+	return
+}
+
+// parseDate returns the day of s, at midnight, or a datetime if s has a time
+// too (hasTime).
+func parseDate(s string, now time.Time) (date time.Time, hasTime bool, err error) {
+	var today = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "", "today":
+		return today, false, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), false, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), false, nil
+	}
+
+	if date, err = time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		return date, false, nil
+	}
+
+	for _, layout := range isoDateTimeLayouts {
+		if date, err = time.ParseInLocation(layout, strings.ToUpper(s), now.Location()); err == nil {
+			return date, true, nil
+		}
+	}
+
+	if s[0] == '-' || s[0] == '+' {
+		date, err = relativeDate(today, s)
+		return date, false, err
+	}
+
+	date, err = weekdayDate(today, s)
+	return date, false, err
+}
+
+// relativeDate parses [+-]N followed by d, w, m or y.
+func relativeDate(today time.Time, s string) (date time.Time, err error) {
+	if len(s) < 3 {
+		return date, fmt.Errorf("invalid date: %s", s)
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return date, fmt.Errorf("invalid date: %s", s)
+	}
+
+	switch s[len(s)-1] {
+	case 'd':
+		date = today.AddDate(0, 0, n)
+	case 'w':
+		date = today.AddDate(0, 0, 7*n)
+	case 'm':
+		date = today.AddDate(0, n, 0)
+	case 'y':
+		date = today.AddDate(n, 0, 0)
+	default:
+		err = fmt.Errorf("invalid date: %s (units: d, w, m, y)", s)
+	}
+
+	return
+}
+
+// weekdayDate parses [last|next] weekday. Without last and next, the day
+// is the last one, today included.
+func weekdayDate(today time.Time, s string) (date time.Time, err error) {
+	var which = ""
+
+	fields := strings.Fields(s)
+	if len(fields) == 2 && (fields[0] == "last" || fields[0] == "next") {
+		which, fields = fields[0], fields[1:]
+	}
+
+	if len(fields) != 1 {
+		return date, fmt.Errorf("invalid date: %s", s)
+	}
+
+	wd, ok := parseWeekday(fields[0])
+	if !ok {
+		return date, fmt.Errorf("invalid date: %s", s)
+	}
+
+	back := (int(today.Weekday()) - int(wd) + 7) % 7
+
+	switch which {
+	case "last":
+		if back == 0 {
+			back = 7
+		}
+	case "next":
+		return today.AddDate(0, 0, 7-back), nil
+	}
+
+	return today.AddDate(0, 0, -back), nil
+}
+
+func parseWeekday(s string) (wd time.Weekday, ok bool) {
+	for wd = time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		if s == name || s == name[:3] {
+			return wd, true
+		}
+	}
+
+	return
+}
+
+// parseClock returns hours, minutes and seconds of s, or of clock if s is
+// empty.
+func parseClock(s string, now time.Time, clock time.Time) (h int, m int, sec int, err error) {
+	var pm, am bool
+	var invalid = fmt.Errorf("invalid time: %s", s)
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "":
+		return clock.Hour(), clock.Minute(), clock.Second(), nil
+	case "now":
+		return now.Hour(), now.Minute(), now.Second(), nil
+	case "noon":
+		return 12, 0, 0, nil
+	case "midnight":
+		return 0, 0, 0, nil
+	}
+
+	if rest, found := strings.CutSuffix(s, "pm"); found {
+		s, pm = strings.TrimSpace(rest), true
+	} else if rest, found := strings.CutSuffix(s, "am"); found {
+		s, am = strings.TrimSpace(rest), true
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, 0, 0, invalid
+	}
+
+	var values [3]int
+	for i, px := range parts {
+		values[i], err = strconv.Atoi(px)
+		if err != nil || px == "" || px[0] == '-' || px[0] == '+' || i > 0 && len(px) != 2 {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	h, m, sec = values[0], values[1], values[2]
+
+	if am || pm {
+		if h < 1 || h > 12 {
+			return 0, 0, 0, invalid
+		}
+
+		h %= 12
+		if pm {
+			h += 12
+		}
+	}
+
+	if h > 23 || m > 59 || sec > 59 {
+		return 0, 0, 0, invalid
+	}
+
+	return
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Monday, 2025-06-23
+var testNow = time.Date(2025, time.June, 23, 10, 15, 30, 0, time.UTC)
+
+func TestParseDate(t *testing.T) {
+	var tests = []struct {
+		in      string
+		want    string // 2006-01-02, empty if in is invalid
+		hasTime bool
+	}{
+		{"", "2025-06-23", false},
+		{"today", "2025-06-23", false},
+		{"yesterday", "2025-06-22", false},
+		{"Tomorrow", "2025-06-24", false},
+		{"2025-06-24", "2025-06-24", false},
+		{"-3d", "2025-06-20", false},
+		{"+1w", "2025-06-30", false},
+		{"-2m", "2025-04-23", false},
+		{"+1y", "2026-06-23", false},
+		{"monday", "2025-06-23", false},
+		{"mon", "2025-06-23", false},
+		{"last monday", "2025-06-16", false},
+		{"next monday", "2025-06-30", false},
+		{"friday", "2025-06-20", false},
+		{"next fri", "2025-06-27", false},
+		{"2025-06-24 14:30", "2025-06-24", true},
+		{"3d", "", false},
+		{"-3x", "", false},
+		{"-d", "", false},
+		{"someday", "", false},
+		{"last", "", false},
+		{"last next monday", "", false},
+		{"2025-13-01", "", false},
+	}
+
+	for _, tt := range tests {
+		date, hasTime, err := parseDate(tt.in, testNow)
+
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("parseDate(%q) = %v, want an error", tt.in, date)
+		case tt.want == "":
+			continue
+		case err != nil:
+			t.Errorf("parseDate(%q): %v", tt.in, err)
+		case date.Format(time.DateOnly) != tt.want || hasTime != tt.hasTime:
+			t.Errorf("parseDate(%q) = %v, %v, want %s, %v", tt.in, date, hasTime, tt.want, tt.hasTime)
+		case !hasTime && (date.Hour() != 0 || date.Minute() != 0 || date.Second() != 0):
+			t.Errorf("parseDate(%q) = %v, want midnight", tt.in, date)
+		}
+	}
+}
+
+func TestParseDateOffset(t *testing.T) {
+	date, hasTime, err := parseDate("2025-06-24T14:30:00+02:00", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2025, time.June, 24, 14, 30, 0, 0, time.FixedZone("", 2*3600))
+	if _, offset := date.Zone(); !hasTime || !date.Equal(want) || offset != 2*3600 {
+		t.Errorf("parseDate = %v, %v, want %v, true", date, hasTime, want)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	var clock = time.Date(2025, time.June, 20, 8, 9, 10, 0, time.UTC)
+
+	var tests = []struct {
+		in      string
+		h, m, s int
+		ok      bool
+	}{
+		{"", 8, 9, 10, true},
+		{"now", 10, 15, 30, true},
+		{"noon", 12, 0, 0, true},
+		{"midnight", 0, 0, 0, true},
+		{"14:30", 14, 30, 0, true},
+		{"14:30:05", 14, 30, 5, true},
+		{"14", 14, 0, 0, true},
+		{"2pm", 14, 0, 0, true},
+		{"2:30 PM", 14, 30, 0, true},
+		{"12am", 0, 0, 0, true},
+		{"12pm", 12, 0, 0, true},
+		{"0pm", 0, 0, 0, false},
+		{"13pm", 0, 0, 0, false},
+		{"24", 0, 0, 0, false},
+		{"2:3", 0, 0, 0, false},
+		{"14:60", 0, 0, 0, false},
+		{"14:30:5", 0, 0, 0, false},
+		{"1:02:03:04", 0, 0, 0, false},
+		{"-1", 0, 0, 0, false},
+		{"+2", 0, 0, 0, false},
+		{":30", 0, 0, 0, false},
+		{"pm", 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		h, m, s, err := parseClock(tt.in, testNow, clock)
+
+		if (err == nil) != tt.ok || h != tt.h || m != tt.m || s != tt.s {
+			t.Errorf("parseClock(%q) = %d, %d, %d, %v, want %d, %d, %d, ok %v", tt.in, h, m, s, err, tt.h, tt.m, tt.s, tt.ok)
+		}
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	var tests = []struct {
+		date, time string
+		want       time.Time // zero if invalid
+	}{
+		{"yesterday", "2pm", time.Date(2025, time.June, 22, 14, 0, 0, 0, time.UTC)},
+		{"", "", testNow},
+		{"2025-06-24T14:30:00+02:00", "", time.Date(2025, time.June, 24, 12, 30, 0, 0, time.UTC)},
+		{"2025-06-24T14:30:00+02:00", "10:00", time.Time{}},
+		{"2025-06-24 14:30", "2pm", time.Time{}},
+		{"yesterday", "25:00", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDateTime(tt.date, tt.time, testNow, testNow)
+
+		switch {
+		case tt.want.IsZero() && err == nil:
+			t.Errorf("parseDateTime(%q, %q) = %v, want an error", tt.date, tt.time, got)
+		case !tt.want.IsZero() && (err != nil || !got.Equal(tt.want)):
+			t.Errorf("parseDateTime(%q, %q) = %v, %v, want %v", tt.date, tt.time, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckArgsEndBeforeInit(t *testing.T) {
+	var saved = args
+
+	defer func() {
+		args = saved
+	}()
+
+	args = arguments{
+		DateInitStr:   "2025-06-24",
+		TimeInitStr:   "14:00",
+		DateEndStr:    "2025-06-24",
+		TimeEndStr:    "13:59",
+		Format:        "markdown",
+		Codec:         CODEC_FLATE,
+		OutputPermStr: "660",
+	}
+
+	err := checkArgs()
+	if err == nil || !strings.Contains(err.Error(), "before") {
+		t.Errorf("checkArgs() = %v, want end before init", err)
+	}
+
+	args.TimeEndStr = "14:00"
+	if err = checkArgs(); err != nil {
+		t.Errorf("checkArgs() = %v, want no error for an empty entry", err)
+	}
+}
@@ -4,45 +4,62 @@ package diary
 
 import (
 	"database/sql"
-	"os"
+	"time"
 
-	"github.com/mattn/go-sqlite3"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-var sqlite3conn *sqlite3.SQLiteConn
-
-func getMaxBlobSize() int64 {
-	return int64(sqlite3conn.GetLimit(sqlite3.SQLITE_LIMIT_LENGTH))
+// Both *sql.DB and *sql.Tx: statements of a Diary run on the database, or in
+// the transaction of inTx.
+type dbtx interface {
+	execer
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
-func touch() (db *sql.DB, err error) {
-	var exists = true
+// inTx runs f in a transaction, committed if f returns nil and rolled back
+// otherwise. f gets a copy of d whose statements run in the transaction; if d
+// is already in one, f joins it.
+func (d *Diary) inTx(f func(dx *Diary) error) (err error) {
+	if d.tx != nil {
+		return f(d)
+	}
+
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+
+	dx := *d
+	dx.db, dx.tx = tx, tx
 
-	if _, errStat := os.Stat(args.Path); errStat != nil {
-		logger.info.Printf("file does not exist: creating;; %s\n", args.Path)
-		exists = false
+	err = f(&dx)
+	if err != nil {
+		tx.Rollback()
+		return
 	}
 
-	sql.Register("sqlite3_2", &sqlite3.SQLiteDriver{
-		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-			sqlite3conn = conn
-			return nil
-		},
-	})
+	return tx.Commit()
+}
+
+func logAnomaly(d *Diary, note string) (err error) {
+	_, err = d.db.Exec("insert into anomalies (inserted, note) values (?, ?)", time.Now().Unix(), note)
+	return
+}
+
+func querySingleInt64Array(db dbtx, query string, params ...any) (res []int64, err error) {
+	var temp int64
+
+	rows, err := db.Query(query, params...)
 
-	db, err = sql.Open("sqlite3_2", args.Path)
 	if err == nil {
-		if !exists {
-			_, err = db.Exec(schema)
+		defer rows.Close()
 
-			if err != nil {
-				db.Close()
-				db = nil
+		for err == nil && rows.Next() {
+			err = rows.Scan(&temp)
 
-				err1 := os.Remove(args.Path)
-				if err1 != nil {
-					logger.err.Printf("created file is corrupted and could not be deleted: delete and do not use")
-				}
+			if err == nil {
+				res = append(res, temp)
 			}
 		}
 	}
@@ -50,8 +67,8 @@ func touch() (db *sql.DB, err error) {
 	return
 }
 
-func querySingleInt64Array(db *sql.DB, query string, params ...any) (res []int64, err error) {
-	var temp int64
+func querySingleStringArray(db dbtx, query string, params ...any) (res []string, err error) {
+	var temp string
 
 	rows, err := db.Query(query, params...)
 
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"crypto/cipher"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Diary is an open diary database. Commands are built on its methods, which
+// do not depend on the command line: options are fields, errors are returned.
+type Diary struct {
+	pool *sql.DB
+	db   dbtx    // pool, or the transaction of inTx
+	tx   *sql.Tx // set by inTx
+	path string
+
+	// nil if the diary is not encrypted or it has not been unlocked yet
+	key  []byte
+	aead cipher.AEAD
+
+	templates *template.Template
+
+	Codec       string      // compression of new contents (see codec.go)
+	Format      string      // note format in dumps: markdown or plain
+	TemplateDir string      // templates overriding the default ones, if set
+	Perm        os.FileMode // permission of written files
+	Log         *log.Logger // progress and warnings, discarded if nil
+
+	// Zone is where days begin and end: dumps, and entries of a day, are
+	// bucketed by its midnights. Entries keep the zone they were written in.
+	Zone *time.Location
+}
+
+// Open opens the diary at path, creating it if it does not exist, and applies
+// pending migrations. Encrypted diaries must be unlocked before use: contents
+// stored by older versions are converted then (see convertInline).
+func Open(path string) (d *Diary, err error) {
+	return open(path, true)
+}
+
+func open(path string, migrateSchema bool) (d *Diary, err error) {
+	var exists = true
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	d = &Diary{
+		path:   path,
+		Codec:  CODEC_FLATE,
+		Format: "markdown",
+		Perm:   0660,
+		Zone:   time.Local,
+	}
+
+	if _, errStat := os.Stat(path); errStat != nil {
+		exists = false
+	}
+
+	d.pool, err = sql.Open("sqlite3", path)
+	d.db = d.pool
+
+	if err == nil && !exists {
+		_, err = d.db.Exec(schema)
+
+		if err != nil {
+			d.pool.Close()
+
+			if err1 := os.Remove(path); err1 != nil {
+				err = fmt.Errorf("%s (created file is corrupted and could not be deleted: delete and do not use)", err.Error())
+			}
+
+			return nil, err
+		}
+	}
+
+	if err == nil && migrateSchema {
+		err = migrate(d)
+	}
+
+	if err == nil && migrateSchema {
+		_, _, err = convertInline(d)
+	}
+
+	if err != nil && d.pool != nil {
+		d.pool.Close()
+		d = nil
+	}
+
+	return
+}
+
+func (d *Diary) Close() error {
+	return d.pool.Close()
+}
+
+// Path is the absolute path of the database file.
+func (d *Diary) Path() string {
+	return d.path
+}
+
+func (d *Diary) logf(format string, v ...any) {
+	if d.Log != nil {
+		d.Log.Printf(format, v...)
+	}
+}
+
+// AddEntry inserts e, setting its Id, and tags it, in a single transaction.
+func (d *Diary) AddEntry(e *Entry, tags []string) (err error) {
+	err = d.inTx(func(dx *Diary) (err error) {
+		err = e.Insert(dx)
+		if err == nil && len(tags) > 0 {
+			err = e.AddTags(dx, tags)
+		}
+
+		return
+	})
+
+	if err != nil {
+		e.Id = -1
+	}
+
+	return
+}
+
+// Entry returns the entry with the given id, deleted or not.
+func (d *Diary) Entry(id int64) (e Entry, err error) {
+	return RetrieveEntryByID(d, id)
+}
+
+// Entries returns the entries, not deleted, starting in [from, to) and
+// having at least one of tags, if any.
+func (d *Diary) Entries(from time.Time, to time.Time, tags []string) (list []Entry, err error) {
+	tagClause, tagParams := tagFilter(tags)
+
+	rows, err := d.db.Query(QUERY_ENTRY_ALL+" where init >= ? and init < ? and deleted = 0"+tagClause+" order by init", append([]any{from.Unix(), to.Unix()}, tagParams...)...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var entry Entry
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			list = append(list, entry)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+// Attach stores the content read from r as an attachment of a.EntryId, which
+// must not be deleted. Name, and optionally Path and Modified, are taken from
+// a; Id, Mime and Sha256 are set. r is read twice, and stored in chunks of
+// CHUNK_SIZE (see InsertFrom): the content is never held in memory as a
+// whole, its size is only limited by the disk.
+func (d *Diary) Attach(a *Attachment, r io.ReadSeeker) (err error) {
+	e, err := RetrieveEntryByID(d, a.EntryId)
+	if err == NOT_FOUND || err == nil && e.Deleted {
+		err = fmt.Errorf("entry #%d not found", a.EntryId)
+	}
+
+	if err == nil {
+		err = a.InsertFrom(d, r)
+	}
+
+	return
+}
+
+// Attachments returns the attachments of an entry, without content.
+func (d *Diary) Attachments(entryId int64) (list []Attachment, err error) {
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.entry_id = ? and a.deleted = 0 order by a.id", entryId)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var a Attachment
+
+		a, err = CreateAttachmentByScanNC(d, rows)
+		if err == nil {
+			list = append(list, a)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+// Fetch writes the content of an attachment, not deleted, to w.
+func (d *Diary) Fetch(id int64, w io.Writer) (a Attachment, err error) {
+	a, err = RetrieveAttachmentByIDNC(d, id)
+	if err == NOT_FOUND || err == nil && a.Deleted {
+		return a, fmt.Errorf("attachment #%d not found", id)
+	}
+
+	if err == nil {
+		_, err = a.WriteContent(d, w)
+	}
+
+	return
+}
+
+// DumpDay writes the dump-day page of the calendar day of date, in d.Zone, to
+// w.
+// dumper makes attachments available to the page, see FileDumper: if nil,
+// they are only listed, no file is written.
+func (d *Diary) DumpDay(w io.Writer, date time.Time, tags []string, dumper AttachmentDumper) (err error) {
+	page, err := dumpDayPage(d, date, tags, dumper)
+	if err == nil {
+		err = d.executeDumpPage(w, "dump_day", page)
+	}
+
+	return
+}
+
+// Dump writes the index, year, month and day pages in dir, which is created
+// if it does not exist. Year directories must be empty, unless force is set:
+// then their content is removed. Attachments are written next to their day
+// page.
+func (d *Diary) Dump(dir string, tags []string, force bool) (err error) {
+	page, years, err := dumpIndexPage(d, tags)
+	if err == nil {
+		err = os.MkdirAll(dir, d.Perm|0100)
+	}
+
+	if err == nil {
+		err = d.writeDumpPage(filepath.Join(dir, "index.html"), "dump_index", page)
+	}
+
+	for _, yx := range years {
+		var dirX = filepath.Join(dir, fmt.Sprintf("%d", yx))
+
+		if err == nil && force {
+			err = d.rmR(dirX, true)
+		}
+
+		if err == nil {
+			err = d.createDirectoryIfNE(dirX)
+		}
+
+		if err == nil {
+			err = dumpSingleYear(d, tags, yx, dirX)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return
+}
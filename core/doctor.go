@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Finding is a problem found by Doctor.
+type Finding struct {
+	Check string // the check that found it, e.g. integrity
+	Note  string
+	Fixed bool
+}
+
+type doctorCheck struct {
+	name string
+
+	// run appends what it finds to *ff, fixing it if fix is set and it can be
+	// fixed safely
+	run func(d *Diary, fix bool, ff *[]Finding) error
+}
+
+var doctorChecks = []doctorCheck{
+	{"integrity", checkIntegrity},
+	{"foreign-key", checkForeignKeys},
+	{"attachment", checkAttachmentEntries},
+	{"entry", checkEntries},
+	{"content", checkEmptyContents},
+}
+
+// Doctor looks for inconsistencies in the database and records them in the
+// anomalies table: what is fixed every time, what is left only once, however
+// many times it is found. If fix is set, what can be safely repaired is.
+func (d *Diary) Doctor(fix bool) (findings []Finding, err error) {
+	for _, cx := range doctorChecks {
+		var found []Finding
+
+		d.logf("Checking %s\n", cx.name)
+
+		err = cx.run(d, fix, &found)
+
+		for ix := 0; ix < len(found) && err == nil; ix++ {
+			var recorded int64
+
+			found[ix].Check = cx.name
+
+			note := "doctor: " + found[ix].Note
+			if found[ix].Fixed {
+				note += " (fixed)"
+			} else {
+				err = d.db.QueryRow("select count(*) from anomalies where note = ?", note).Scan(&recorded)
+			}
+
+			if err == nil && recorded == 0 {
+				err = logAnomaly(d, note)
+			}
+		}
+
+		findings = append(findings, found...)
+
+		if err != nil {
+			break
+		}
+	}
+
+	return
+}
+
+func checkIntegrity(d *Diary, _ bool, ff *[]Finding) (err error) {
+	problems, err := integrityCheck(d.db)
+
+	for _, px := range problems {
+		*ff = append(*ff, Finding{Note: px})
+	}
+
+	return
+}
+
+// checkForeignKeys reports the rows referring to missing ones. Attachments
+// are left to the following checks; tags of missing entries, or missing tags,
+// are removed.
+func checkForeignKeys(d *Diary, fix bool, ff *[]Finding) (err error) {
+	type violation struct {
+		table  string
+		rowid  sql.NullInt64
+		parent string
+	}
+
+	var violations []violation
+
+	rows, err := d.db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return
+	}
+
+	for rows.Next() && err == nil {
+		var vx violation
+		var fkid int64
+
+		err = rows.Scan(&vx.table, &vx.rowid, &vx.parent, &fkid)
+		if err == nil && vx.table != "attachments" {
+			violations = append(violations, vx)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	rows.Close()
+
+	for ix := 0; ix < len(violations) && err == nil; ix++ {
+		var vx = violations[ix]
+		var f = Finding{Note: fmt.Sprintf("%s row %d refers to missing %s", vx.table, vx.rowid.Int64, vx.parent)}
+
+		if fix && vx.table == "entry_tags" && vx.rowid.Valid {
+			_, err = d.db.Exec("delete from entry_tags where rowid = ?", vx.rowid.Int64)
+			f.Fixed = err == nil
+		}
+
+		*ff = append(*ff, f)
+	}
+
+	return
+}
+
+// checkAttachmentEntries reports attachments of missing entries, which are
+// deleted (purge removes them), and attachments, not deleted, added to
+// entries after they were deleted. Other attachments of deleted entries are
+// fine: they were there when the entry was deleted, and are restored with it.
+func checkAttachmentEntries(d *Diary, fix bool, ff *[]Finding) (err error) {
+	orphans, err := querySingleInt64Array(d.db, "select id from attachments where deleted = 0 and entry_id not in (select id from entries) order by id")
+
+	for ix := 0; ix < len(orphans) && err == nil; ix++ {
+		var f = Finding{Note: fmt.Sprintf("attachment #%d belongs to a missing entry", orphans[ix])}
+
+		if fix {
+			_, err = d.db.Exec("update attachments set deleted = 1, deleted_at = ? where id = ?", time.Now().Unix(), orphans[ix])
+			f.Fixed = err == nil
+		}
+
+		*ff = append(*ff, f)
+	}
+
+	if err != nil {
+		return
+	}
+
+	late, err := querySingleInt64Array(d.db, "select a.id from attachments a join entries e on e.id = a.entry_id where a.deleted = 0 and e.deleted = 1 and a.inserted > e.deleted_at order by a.id")
+
+	for _, ax := range late {
+		*ff = append(*ff, Finding{Note: fmt.Sprintf("attachment #%d was added to a deleted entry", ax)})
+	}
+
+	return
+}
+
+// checkEntries reports entries ending before they begin, whose end is set to
+// their beginning, and entries with an empty note.
+func checkEntries(d *Diary, fix bool, ff *[]Finding) (err error) {
+	var entries []Entry
+
+	rows, err := d.db.Query(QUERY_ENTRY_ALL + " where deleted = 0 order by id")
+	if err != nil {
+		return
+	}
+
+	for rows.Next() && err == nil {
+		var entry Entry
+
+		entry, err = CreateEntryByScan(d, rows)
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	rows.Close()
+
+	for ix := 0; ix < len(entries) && err == nil; ix++ {
+		var entry = entries[ix]
+
+		if entry.End.Before(entry.Init) {
+			var f = Finding{Note: fmt.Sprintf("entry #%d ends before it begins", entry.Id)}
+
+			if fix {
+				_, err = d.db.Exec("update entries set fin = init where id = ?", entry.Id)
+				f.Fixed = err == nil
+			}
+
+			*ff = append(*ff, f)
+		}
+
+		if strings.TrimSpace(entry.Note) == "" {
+			*ff = append(*ff, Finding{Note: fmt.Sprintf("entry #%d has an empty note", entry.Id)})
+		}
+	}
+
+	return
+}
+
+// checkEmptyContents reports attachments whose content is empty or missing:
+// without a blob, or without chunks.
+func checkEmptyContents(d *Diary, _ bool, ff *[]Finding) (err error) {
+	ids, err := querySingleInt64Array(d.db, "select a.id from "+ATTACHMENT_FROM+" where a.deleted = 0 and (coalesce("+ATTACHMENT_SIZE+", 0) = 0 or not exists (select 1 from blob_chunks c where c.hash = a.hash)) order by a.id")
+
+	for _, ax := range ids {
+		*ff = append(*ff, Finding{Note: fmt.Sprintf("attachment #%d has no content", ax)})
+	}
+
+	return
+}
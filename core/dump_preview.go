@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Longest side of thumbnails, in pixels
+const THUMB_SIZE = 320
+
+// Images with more pixels are not decoded to make a thumbnail
+const THUMB_MAX_PIXELS = 40_000_000
+
+// Text attachments are shown up to this size
+const PREVIEW_TEXT_MAX = 64 * 1024
+
+// An AttachmentDumper makes an attachment available to a dump-day page:
+// it sets the Href of da and its preview (see previewAttachment).
+type AttachmentDumper func(d *Diary, a Attachment, da *DumpAttachment) error
+
+var errHeadFull = errors.New("head full")
+
+// headWriter keeps the first len(buf) bytes written to it, then fails with
+// errHeadFull to stop the writer.
+type headWriter struct {
+	buf []byte
+	n   int
+}
+
+func (h *headWriter) Write(p []byte) (n int, err error) {
+	n = copy(h.buf[h.n:], p)
+	h.n += n
+
+	if n < len(p) {
+		err = errHeadFull
+	}
+
+	return
+}
+
+// readHead returns the first size bytes of the attachment content, without
+// decompressing the rest.
+func readHead(d *Diary, a Attachment, size int) (head []byte, err error) {
+	var h = headWriter{buf: make([]byte, size)}
+
+	_, err = a.WriteContent(d, &h)
+	if err == errHeadFull {
+		err = nil
+	}
+
+	return h.buf[:h.n], err
+}
+
+// previewAttachment sets the kind of preview of da and, for texts, the text
+// to show; Thumb is left to the dumper. mime is detected when unknown (older
+// rows).
+func previewAttachment(d *Diary, a Attachment, da *DumpAttachment) (err error) {
+	var mime = a.Mime
+	var head []byte
+
+	if mime == "" {
+		head, err = readHead(d, a, 512)
+		if err != nil {
+			return
+		}
+
+		mime = http.DetectContentType(head)
+	}
+
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		da.Preview = "image"
+	case strings.HasPrefix(mime, "audio/"), mime == "application/ogg":
+		da.Preview = "audio"
+	case strings.HasPrefix(mime, "video/"):
+		da.Preview = "video"
+	case strings.HasPrefix(mime, "text/"):
+		da.Preview = "text"
+
+		head, err = readHead(d, a, PREVIEW_TEXT_MAX+1)
+		if err == nil {
+			da.Text, da.Truncated = previewText(head)
+		}
+	}
+
+	return
+}
+
+func previewText(head []byte) (text string, truncated bool) {
+	var n = len(head)
+
+	truncated = n > PREVIEW_TEXT_MAX
+	if truncated {
+		n = PREVIEW_TEXT_MAX
+
+		// do not cut a rune
+		for n > 0 && !utf8.RuneStart(head[n]) {
+			n--
+		}
+	}
+
+	return strings.ToValidUTF8(string(head[:n]), "�"), truncated
+}
+
+// writeThumbnail scales the image read from r down to THUMB_SIZE and writes
+// it to w as JPEG. Nothing is written, and ok is false, if the image is
+// already small enough, too big to be decoded (see THUMB_MAX_PIXELS) or its
+// format is not supported (a browser may be).
+func writeThumbnail(r io.ReadSeeker, w io.Writer) (ok bool, err error) {
+	config, _, errConfig := image.DecodeConfig(r)
+	if errConfig != nil || config.Width <= THUMB_SIZE && config.Height <= THUMB_SIZE {
+		return
+	}
+
+	if int64(config.Width)*int64(config.Height) > THUMB_MAX_PIXELS {
+		return
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	img, _, err := image.Decode(r)
+	if err == nil {
+		err = jpeg.Encode(w, scaleDown(img, THUMB_SIZE), &jpeg.Options{Quality: 80})
+	}
+
+	return err == nil, err
+}
+
+// scaleDown resizes img so that its longest side is size, averaging the
+// source pixels of each destination pixel (box filter). Transparent areas
+// become white, as JPEG has no alpha.
+func scaleDown(img image.Image, size int) *image.RGBA {
+	var b = img.Bounds()
+	var w, h = size, size
+
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	at := pixelAt(img)
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+
+		for x := 0; x < w; x++ {
+			var r, g, bl, a, n uint64
+
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := at(sx, sy)
+					r += uint64(sr)
+					g += uint64(sg)
+					bl += uint64(sb)
+					a += uint64(sa)
+					n++
+				}
+			}
+
+			// premultiplied, over white
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((bl + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
+
+// pixelAt returns a function reading the color of img at x, y, premultiplied,
+// 16 bits per channel, as color.Color.RGBA. The usual formats are read from
+// their pixels: img.At allocates a color for each of them.
+func pixelAt(img image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch src := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := src.YOffset(x, y), src.COffset(x, y)
+			r8, g8, b8 := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+			return uint32(r8) * 0x101, uint32(g8) * 0x101, uint32(b8) * 0x101, 0xffff
+		}
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := src.Pix[src.PixOffset(x, y):]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101, uint32(p[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := src.Pix[src.PixOffset(x, y):]
+			a = uint32(p[3]) * 0x101
+			return uint32(p[0]) * 0x101 * a / 0xffff, uint32(p[1]) * 0x101 * a / 0xffff, uint32(p[2]) * 0x101 * a / 0xffff, a
+		}
+	}
+
+	return func(x, y int) (r, g, b, a uint32) {
+		return img.At(x, y).RGBA()
+	}
+}
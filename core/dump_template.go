@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Templates are looked up by name (see {{define}} in res/templates).
+// A user template directory can redefine any of them.
+//
+//go:embed res/templates/*.html
+var templatesFS embed.FS
+
+type DumpLink struct {
+	Href string
+	Text string
+}
+
+type DumpAttachment struct {
+	Id       int64
+	Size     string
+	Name     string
+	Href     string
+	Mime     string
+	Sha256   string
+	Path     string
+	Modified string
+
+	// see previewAttachment
+	Preview   string // image, audio, video, text or empty
+	Thumb     string
+	Text      string
+	Truncated bool
+}
+
+type DumpEntry struct {
+	Id          int64
+	Init        string
+	End         string
+	Tags        []string
+	Note        template.HTML // already rendered, see NoteRenderer
+	Attachments []DumpAttachment
+}
+
+type DumpPage struct {
+	Title   string
+	Links   []DumpLink
+	Entries []DumpEntry
+}
+
+// loadDumpTemplates parses the templates once: d can then render pages
+// concurrently.
+func (d *Diary) loadDumpTemplates() (t *template.Template, err error) {
+	if d.templates != nil {
+		return d.templates, nil
+	}
+
+	t, err = template.ParseFS(templatesFS, "res/templates/*.html")
+
+	if err == nil && d.TemplateDir != "" {
+		d.logf("Loading templates from %s\n", d.TemplateDir)
+		t, err = t.ParseGlob(filepath.Join(d.TemplateDir, "*.html"))
+	}
+
+	if err == nil {
+		d.templates = t
+	}
+
+	return
+}
+
+func (d *Diary) writeDumpPage(path string, name string, page DumpPage) (err error) {
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, d.Perm)
+	if err != nil {
+		return
+	}
+
+	defer fp.Close()
+
+	return d.executeDumpPage(fp, name, page)
+}
+
+func (d *Diary) executeDumpPage(w io.Writer, name string, page DumpPage) (err error) {
+	t, err := d.loadDumpTemplates()
+	if err == nil {
+		err = t.ExecuteTemplate(w, name, page)
+	}
+
+	return
+}
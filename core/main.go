@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	_ "embed"
 )
@@ -14,8 +13,6 @@ import (
 //go:embed res/help.txt
 var HELP_PAGE string
 
-var stdnull *os.File
-
 func Run() {
 	logger.info = log.New(os.Stderr, "[\033[34mINFO \033[0m] ", 0)
 	logger.warn = log.New(os.Stderr, "[\033[33mWARN \033[0m] ", 0)
@@ -29,7 +26,7 @@ func Run() {
 	myerr(err, true)
 
 	if args.Help {
-		fmt.Print(HELP_PAGE)
+		fmt.Print(firstNonEmpty(args.Usage, HELP_PAGE))
 		os.Exit(0)
 	}
 
@@ -37,32 +34,28 @@ func Run() {
 		logger.info = log.New(stdnull, "[\033[34mINFO \033[0m] ", 0)
 	}
 
-	db, err := touch()
-	myerr(err, true)
-	defer db.Close()
+	cmd, _ := lookupCommand(args.Command)
 
-	switch strings.ToLower(args.Command) {
-	case "add":
-		err = cmdAdd(db)
-	case "resume":
-		err = cmdResume(db)
-	case "dump-day":
-		err = cmdDumpDay(db)
-	case "dump":
-		err = cmdDump(db)
-	case "delete":
-		err = cmdDelete(db)
-	case "fetch":
-		err = cmdFetch(db)
-	case "license":
-		err = cmdLicense(db)
-	case "info":
-		err = cmdInfo(db)
-	case "add-attach":
-		err = cmdAddAttach(db)
-	default:
-		logger.err.Printf("invalid command: %s", args.Command)
+	if _, errStat := os.Stat(args.Path); errStat != nil {
+		logger.info.Printf("file does not exist: creating;; %s\n", args.Path)
+	}
+
+	d, err := open(args.Path, !cmd.noMigrate)
+	myerr(err, true)
+	defer d.Close()
+
+	d.Codec = args.Codec
+	d.Format = args.Format
+	d.TemplateDir = args.TemplateDir
+	d.Perm = os.FileMode(args.OutputPerm)
+	d.Zone = args.Zone
+	d.Log = logger.info
+
+	if !cmd.noUnlock {
+		err = unlock(d)
+		myerr(err, true)
 	}
 
+	err = cmd.run(d)
 	myerr(err, false)
 }
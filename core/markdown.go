@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// A NoteRenderer turns a note into the HTML used by dumps: the result is
+// trusted by templates, so it must escape the note itself.
+type NoteRenderer func(note string) string
+
+// Renderers selectable with -format.
+var noteRenderers = map[string]NoteRenderer{
+	"markdown": renderMarkdown,
+	"plain":    renderPlain,
+}
+
+func renderPlain(note string) string {
+	return strings.Replace(html.EscapeString(note), "\n", "<br>", -1)
+}
+
+var (
+	mdHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdRule       = regexp.MustCompile(`^([-*_])(\s*([-*_]))*$`)
+	mdUListItem  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	mdOListItem  = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	mdBlockquote = regexp.MustCompile(`^>\s?(.*)$`)
+
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEm     = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+)
+
+// renderMarkdown supports a subset of Markdown: headings, paragraphs, lists,
+// block quotes, fenced code blocks, horizontal rules, inline code, links and
+// emphasis. Raw HTML is escaped.
+func renderMarkdown(note string) string {
+	var sb strings.Builder
+	var paragraph []string
+	var list string
+	var inCode bool
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(&sb, "<p>%s</p>\n", mdInline(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+	}
+
+	openList := func(tag string) {
+		flushParagraph()
+
+		if list != tag {
+			if list != "" {
+				fmt.Fprintf(&sb, "</%s>\n", list)
+			}
+
+			fmt.Fprintf(&sb, "<%s>\n", tag)
+			list = tag
+		}
+	}
+
+	closeBlocks := func() {
+		flushParagraph()
+
+		if list != "" {
+			fmt.Fprintf(&sb, "</%s>\n", list)
+			list = ""
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(note, "\r\n", "\n"), "\n") {
+		var trimmed = strings.TrimSpace(line)
+		var m []string
+
+		if inCode {
+			if strings.HasPrefix(trimmed, "```") {
+				sb.WriteString("</code></pre>\n")
+				inCode = false
+			} else {
+				sb.WriteString(html.EscapeString(line) + "\n")
+			}
+
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			closeBlocks()
+			sb.WriteString("<pre><code>")
+			inCode = true
+
+		case trimmed == "":
+			closeBlocks()
+
+		case mdRule.MatchString(trimmed) && len(strings.ReplaceAll(trimmed, " ", "")) >= 3:
+			closeBlocks()
+			sb.WriteString("<hr>\n")
+
+		case mdHeading.MatchString(trimmed):
+			m = mdHeading.FindStringSubmatch(trimmed)
+			closeBlocks()
+			fmt.Fprintf(&sb, "<h%d>%s</h%d>\n", len(m[1]), mdInline(m[2]), len(m[1]))
+
+		case mdUListItem.MatchString(trimmed):
+			m = mdUListItem.FindStringSubmatch(trimmed)
+			openList("ul")
+			fmt.Fprintf(&sb, "<li>%s</li>\n", mdInline(m[1]))
+
+		case mdOListItem.MatchString(trimmed):
+			m = mdOListItem.FindStringSubmatch(trimmed)
+			openList("ol")
+			fmt.Fprintf(&sb, "<li>%s</li>\n", mdInline(m[1]))
+
+		case mdBlockquote.MatchString(trimmed):
+			m = mdBlockquote.FindStringSubmatch(trimmed)
+			closeBlocks()
+			fmt.Fprintf(&sb, "<blockquote>%s</blockquote>\n", mdInline(m[1]))
+
+		default:
+			if list != "" {
+				closeBlocks()
+			}
+
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+
+	if inCode {
+		sb.WriteString("</code></pre>\n")
+	}
+
+	closeBlocks()
+
+	return sb.String()
+}
+
+// mdInline renders code spans, links and emphasis. Line breaks are kept.
+func mdInline(text string) string {
+	var sb strings.Builder
+	var chunks = strings.Split(text, "`")
+
+	// an unmatched backtick is not a code span
+	if len(chunks)%2 == 0 {
+		last := len(chunks) - 1
+		chunks = append(chunks[:last-1], chunks[last-1]+"`"+chunks[last])
+	}
+
+	// odd chunks are code spans
+	for i, chunk := range chunks {
+		if i%2 == 1 {
+			sb.WriteString("<code>" + html.EscapeString(chunk) + "</code>")
+			continue
+		}
+
+		// emphasis in the text around links and in their text, never in URLs
+		var last = 0
+
+		chunk = html.EscapeString(chunk)
+		for _, m := range mdLink.FindAllStringSubmatchIndex(chunk, -1) {
+			text, url := chunk[m[2]:m[3]], chunk[m[4]:m[5]]
+
+			sb.WriteString(mdEmphasis(chunk[last:m[0]]))
+
+			if mdSafeURL(html.UnescapeString(url)) {
+				fmt.Fprintf(&sb, "<a href=\"%s\" target=\"_blank\">%s</a>", url, mdEmphasis(text))
+			} else {
+				sb.WriteString(mdEmphasis(text))
+			}
+
+			last = m[1]
+		}
+
+		sb.WriteString(mdEmphasis(chunk[last:]))
+	}
+
+	return sb.String()
+}
+
+// mdEmphasis renders emphasis of escaped text, keeping line breaks.
+func mdEmphasis(text string) string {
+	text = mdStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = mdEm.ReplaceAllString(text, "<em>$1$2</em>")
+
+	return strings.ReplaceAll(text, "\n", "<br>\n")
+}
+
+// mdSafeURL allows relative URLs and a few harmless schemes.
+func mdSafeURL(url string) bool {
+	scheme, _, found := strings.Cut(url, ":")
+
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+
+	return false
+}
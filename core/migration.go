@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are named NNNN_description.sql, where NNNN is the schema version
+// (PRAGMA user_version) the diary has once the migration is applied.
+// Scripts are run in a transaction: they must not contain BEGIN/COMMIT.
+//
+//go:embed res/migrations/*.sql
+var migrationsFS embed.FS
+
+type Migration struct {
+	Version int64
+	Name    string
+	Script  string
+}
+
+func loadMigrations() (mm []Migration, err error) {
+	return loadScripts(migrationsFS, "res/migrations")
+}
+
+// loadScripts loads the numbered scripts of dir, in order: see above.
+func loadScripts(fsys embed.FS, dir string) (mm []Migration, err error) {
+	ddee, err := fsys.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, dex := range ddee {
+		var m Migration
+		var script []byte
+
+		prefix, name, found := strings.Cut(strings.TrimSuffix(dex.Name(), ".sql"), "_")
+		if !found {
+			err = fmt.Errorf("invalid migration name: %s", dex.Name())
+			return
+		}
+
+		m.Version, err = strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid migration name: %s", dex.Name())
+			return
+		}
+
+		script, err = fsys.ReadFile(path.Join(dir, dex.Name()))
+		if err != nil {
+			return
+		}
+
+		m.Name = name
+		m.Script = string(script)
+		mm = append(mm, m)
+	}
+
+	sort.Slice(mm, func(i, j int) bool {
+		return mm[i].Version < mm[j].Version
+	})
+
+	for i, mx := range mm {
+		if mx.Version != int64(i+1) {
+			err = fmt.Errorf("migration %04d_%s is out of sequence", mx.Version, mx.Name)
+			return
+		}
+	}
+
+	return
+}
+
+func schemaVersion(db *sql.DB) (version int64, err error) {
+	err = db.QueryRow("PRAGMA user_version").Scan(&version)
+	return
+}
+
+func pendingMigrations(db *sql.DB) (version int64, pending []Migration, err error) {
+	mm, err := loadMigrations()
+	if err != nil {
+		return
+	}
+
+	version, err = schemaVersion(db)
+	if err != nil {
+		return
+	}
+
+	if version > int64(len(mm)) {
+		err = fmt.Errorf("diary schema version %d is newer than the latest known (%d): update diary", version, len(mm))
+		return
+	}
+
+	pending = mm[version:]
+	return
+}
+
+func (m Migration) Apply(db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(m.Script)
+
+	if err == nil {
+		// PRAGMA does not support placeholders
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version))
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %04d_%s: %s", m.Version, m.Name, err.Error())
+	}
+
+	return tx.Commit()
+}
+
+func migrate(d *Diary) (err error) {
+	_, pending, err := pendingMigrations(d.pool)
+
+	for _, mx := range pending {
+		if err != nil {
+			break
+		}
+
+		d.logf("Applying migration %04d_%s\n", mx.Version, mx.Name)
+		err = mx.Apply(d.pool)
+	}
+
+	return
+}
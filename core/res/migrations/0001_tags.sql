@@ -0,0 +1,16 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Diaries created by older versions may already have these tables */
+
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER primary key AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS entry_tags (
+    entry_id INTEGER,
+    tag_id INTEGER,
+    PRIMARY KEY(entry_id, tag_id),
+    FOREIGN KEY(entry_id) REFERENCES entries(id),
+    FOREIGN KEY(tag_id) REFERENCES tags(id)
+);
@@ -0,0 +1,3 @@
+/* SPDX-License-Identifier: MIT */
+
+ALTER TABLE attachments ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+/* SPDX-License-Identifier: MIT */
+
+/* When a row has been soft-deleted, NULL for rows deleted before this migration */
+
+ALTER TABLE entries ADD COLUMN deleted_at INTEGER;
+ALTER TABLE attachments ADD COLUMN deleted_at INTEGER;
@@ -0,0 +1,8 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Diary-wide settings, e.g. encryption parameters */
+
+CREATE TABLE metadata (
+    key TEXT primary key,
+    value BLOB
+);
@@ -0,0 +1,14 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Attachment contents are stored once, keyed by their hash (see blobHash).
+ * attachments.content is only used by rows not converted yet (see dedup). */
+
+CREATE TABLE blobs (
+    hash TEXT primary key,
+    size INTEGER,
+    content BLOB
+);
+
+ALTER TABLE attachments ADD COLUMN hash TEXT REFERENCES blobs(hash);
+
+CREATE INDEX attachments_hash ON attachments(hash);
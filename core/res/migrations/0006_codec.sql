@@ -0,0 +1,6 @@
+/* SPDX-License-Identifier: MIT */
+
+/* How content is compressed, empty if it is not (see codec.go) */
+
+ALTER TABLE blobs ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+ALTER TABLE attachments ADD COLUMN codec TEXT NOT NULL DEFAULT '';
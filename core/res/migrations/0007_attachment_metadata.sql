@@ -0,0 +1,13 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Metadata of the attached file, NULL when unknown (older rows).
+ * sha256 and path are sealed on encrypted diaries (see crypto.go). */
+
+ALTER TABLE attachments ADD COLUMN mime TEXT;
+ALTER TABLE attachments ADD COLUMN sha256 TEXT;
+ALTER TABLE attachments ADD COLUMN path TEXT;
+ALTER TABLE attachments ADD COLUMN mtime INTEGER;
+
+/* Blob hashes are plain SHA-256 unless the diary is encrypted */
+UPDATE attachments SET sha256 = hash
+WHERE hash IS NOT NULL AND NOT EXISTS (SELECT 1 FROM metadata WHERE key = 'data_key');
@@ -0,0 +1,6 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Time zone the entry was written in: an IANA name (Europe/Rome) or a fixed
+ * offset (+02:00). NULL for older rows, which are read in the local zone. */
+
+ALTER TABLE entries ADD COLUMN zone TEXT;
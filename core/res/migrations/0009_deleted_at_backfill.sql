@@ -0,0 +1,7 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Rows deleted before 0003_deleted_at have no deletion time: their retention
+ * period starts now, instead of being purged at once. */
+
+UPDATE entries SET deleted_at = strftime('%s', 'now') WHERE deleted = 1 AND deleted_at IS NULL;
+UPDATE attachments SET deleted_at = strftime('%s', 'now') WHERE deleted = 1 AND deleted_at IS NULL;
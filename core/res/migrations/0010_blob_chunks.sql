@@ -0,0 +1,13 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Contents are stored in chunks of CHUNK_SIZE bytes, each compressed and
+ * sealed on its own, so that they are written and read one chunk at a time.
+ * blobs.content is only used by blobs not converted yet (see convertInline). */
+
+CREATE TABLE blob_chunks (
+    hash TEXT REFERENCES blobs(hash),
+    seq INTEGER,
+    codec TEXT NOT NULL DEFAULT '',
+    data BLOB,
+    PRIMARY KEY (hash, seq)
+);
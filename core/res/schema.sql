@@ -1,5 +1,8 @@
 /* SPDX-License-Identifier: MIT */
 
+/* Baseline schema (user_version 0), used to create new diaries.
+ * Do not change it: any change to the schema goes in res/migrations. */
+
 PRAGMA foreign_keys=OFF;
 BEGIN TRANSACTION;
 
@@ -0,0 +1,45 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Full-text index over entries' notes and attachments' names.
+ * The index is created the first time the search command is used and then
+ * kept in sync by triggers. It needs SQLite to be built with FTS5.
+ * Executed in a transaction by touchSearchIndex. */
+
+CREATE VIRTUAL TABLE entries_fts USING fts5(note, attachments);
+
+INSERT INTO entries_fts (rowid, note, attachments)
+    SELECT e.id, e.note, coalesce((SELECT group_concat(a.name, ' ') FROM attachments a WHERE a.entry_id = e.id), '')
+    FROM entries e;
+
+CREATE TRIGGER entries_fts_ai AFTER INSERT ON entries BEGIN
+    INSERT INTO entries_fts (rowid, note, attachments) VALUES (new.id, new.note, '');
+END;
+
+CREATE TRIGGER entries_fts_au AFTER UPDATE OF note ON entries BEGIN
+    UPDATE entries_fts SET note = new.note WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER entries_fts_ad AFTER DELETE ON entries BEGIN
+    DELETE FROM entries_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER attachments_fts_ai AFTER INSERT ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = new.entry_id), '')
+        WHERE rowid = new.entry_id;
+END;
+
+CREATE TRIGGER attachments_fts_au AFTER UPDATE OF name, entry_id ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = old.entry_id), '')
+        WHERE rowid = old.entry_id;
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = new.entry_id), '')
+        WHERE rowid = new.entry_id;
+END;
+
+CREATE TRIGGER attachments_fts_ad AFTER DELETE ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = old.entry_id), '')
+        WHERE rowid = old.entry_id;
+END;
@@ -0,0 +1,32 @@
+/* SPDX-License-Identifier: MIT */
+
+/* Names of deleted attachments are not indexed: the triggers created by
+ * search.sql are replaced, and the index is refreshed. */
+
+DROP TRIGGER IF EXISTS attachments_fts_ai;
+DROP TRIGGER IF EXISTS attachments_fts_au;
+DROP TRIGGER IF EXISTS attachments_fts_ad;
+
+CREATE TRIGGER attachments_fts_ai AFTER INSERT ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = new.entry_id AND deleted = 0), '')
+        WHERE rowid = new.entry_id;
+END;
+
+CREATE TRIGGER attachments_fts_au AFTER UPDATE OF name, entry_id, deleted ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = old.entry_id AND deleted = 0), '')
+        WHERE rowid = old.entry_id;
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = new.entry_id AND deleted = 0), '')
+        WHERE rowid = new.entry_id;
+END;
+
+CREATE TRIGGER attachments_fts_ad AFTER DELETE ON attachments BEGIN
+    UPDATE entries_fts
+        SET attachments = coalesce((SELECT group_concat(name, ' ') FROM attachments WHERE entry_id = old.entry_id AND deleted = 0), '')
+        WHERE rowid = old.entry_id;
+END;
+
+UPDATE entries_fts
+    SET attachments = coalesce((SELECT group_concat(a.name, ' ') FROM attachments a WHERE a.entry_id = entries_fts.rowid AND a.deleted = 0), '');
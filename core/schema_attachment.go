@@ -3,85 +3,220 @@
 package diary
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"time"
 )
 
-const QUERY_ATTACHMENT_ALL = "select id, name, inserted, entry_id, content from attachments"
-const QUERY_ATTACHMENT_NC = "select id, name, inserted, entry_id from attachments"
-const QUERY_ATTACHMENT_OC = "select content from attachments"
+// Contents are in the chunks of blobs (see CHUNK_SIZE), never loaded by
+// queries. Sizes are plain, of blobs: 0 if the blob is missing.
+const ATTACHMENT_FROM = "attachments a left join blobs b on b.hash = a.hash"
+const ATTACHMENT_SIZE = "coalesce(b.size, 0)"
+
+const QUERY_ATTACHMENT_NC = "select a.id, a.name, a.inserted, a.entry_id, a.deleted, a.deleted_at, a.mime, a.sha256, a.path, a.mtime, " + ATTACHMENT_SIZE + " from " + ATTACHMENT_FROM
 
 type Attachment struct {
-	Id       int64
-	Name     string
-	Inserted time.Time
-	EntryId  int64
-	Content  []byte
+	Id        int64
+	Name      string
+	Inserted  time.Time
+	EntryId   int64
+	Deleted   bool
+	DeletedAt time.Time // zero unless Deleted
+	Size      int64
+	Content   []byte
+
+	// Of the attached file, empty when unknown
+	Mime     string
+	Sha256   string
+	Path     string
+	Modified time.Time
 }
 
-func CreateAttachmentByScan(rows *sql.Rows) (a Attachment, err error) {
-	var insertedIn int64
+// attachmentMeta scans the nullable columns, partly sealed.
+type attachmentMeta struct {
+	deletedAt sql.NullInt64
 
-	err = rows.Scan(&a.Id, &a.Name, &insertedIn, &a.EntryId, &a.Content)
-	if err != nil {
-		return
+	mime   sql.NullString
+	sha256 []byte
+	path   []byte
+	mtime  sql.NullInt64
+}
+
+func (m *attachmentMeta) apply(d *Diary, a *Attachment) (err error) {
+	a.Mime = m.mime.String
+
+	a.Sha256, err = d.unsealString(m.sha256)
+	if err == nil {
+		a.Path, err = d.unsealString(m.path)
 	}
 
-	a.Inserted = time.Unix(insertedIn, 0)
+	if m.mtime.Valid {
+		a.Modified = time.Unix(m.mtime.Int64, 0)
+	}
+
+	if m.deletedAt.Valid {
+		a.DeletedAt = time.Unix(m.deletedAt.Int64, 0)
+	}
 
 	return
 }
 
-func CreateAttachmentByScanNC(db *sql.DB, rows *sql.Rows) (a Attachment, err error) {
+func CreateAttachmentByScanNC(d *Diary, rows *sql.Rows) (a Attachment, err error) {
 	var insertedIn int64
+	var deleted int64
+	var meta attachmentMeta
 
-	err = rows.Scan(&a.Id, &a.Name, &insertedIn, &a.EntryId)
+	err = rows.Scan(&a.Id, &a.Name, &insertedIn, &a.EntryId, &deleted, &meta.deletedAt, &meta.mime, &meta.sha256, &meta.path, &meta.mtime, &a.Size)
+	if err == nil {
+		err = meta.apply(d, &a)
+	}
 	if err != nil {
 		return
 	}
 
 	a.Inserted = time.Unix(insertedIn, 0)
+	a.Deleted = deleted != 0
+
+	return
+}
+
+// RetrieveContent loads the whole content in a.Content: WriteContent does not.
+func (a *Attachment) RetrieveContent(d *Diary) (err error) {
+	var buf bytes.Buffer
+
+	_, err = a.WriteContent(d, &buf)
+	if err == nil {
+		a.Content = buf.Bytes()
+	}
+
+	return
+}
+
+// WriteContent writes the content to w one chunk at a time, see CHUNK_SIZE.
+func (a *Attachment) WriteContent(d *Diary, w io.Writer) (n int64, err error) {
+	var hash string
+	var size int64
+
+	err = d.db.QueryRow("select b.hash, b.size from "+ATTACHMENT_FROM+" where a.id = ? and b.hash is not null", a.Id).Scan(&hash, &size)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("could not find attachment #%d content", a.Id)
+	}
+
+	if err == nil {
+		n, err = writeBlobTo(d, w, hash)
+	}
 
-	err = a.RetrieveContent(db)
+	if err == nil && n != size {
+		err = fmt.Errorf("attachment #%d content is incomplete: %d bytes out of %d", a.Id, n, size)
+	}
 
 	return
 }
 
-func (a *Attachment) RetrieveContent(db *sql.DB) (err error) {
-	rowc, err := db.Query(QUERY_ATTACHMENT_OC+" where id = ?", a.Id)
+func (a *Attachment) Insert(d *Diary) (err error) {
+	return a.InsertFrom(d, bytes.NewReader(a.Content))
+}
+
+// InsertFrom is Insert reading the content from r: a.Content is not used. r is
+// read once to detect Mime and Sha256, then once more to be stored one chunk
+// at a time, unless the same content is already stored.
+func (a *Attachment) InsertFrom(d *Diary, r io.ReadSeeker) (err error) {
+	var path, mtime any
+	var hash string
+
+	a.Mime, a.Sha256, hash, err = sniffContent(d, r)
 	if err != nil {
 		return
 	}
 
-	defer rowc.Close()
+	if a.Path != "" {
+		path = d.sealString(a.Path)
+	}
 
-	if !rowc.Next() {
-		err = fmt.Errorf("could not find attachment #%d content", a.Id)
-		return
+	if !a.Modified.IsZero() {
+		mtime = a.Modified.Unix()
 	}
 
-	err = rowc.Scan(&a.Content)
-	if err != nil {
+	if a.Inserted.IsZero() {
+		a.Inserted = time.Now()
+	}
+
+	return d.inTx(func(dx *Diary) (err error) {
+		_, err = storeBlobFrom(dx, dx.db, hash, r)
+		if err == nil {
+			var res sql.Result
+
+			res, err = dx.db.Exec("insert into attachments (name, inserted, hash, entry_id, deleted, mime, sha256, path, mtime) values (?, ?, ?, ?, 0, ?, ?, ?, ?)",
+				a.Name, a.Inserted.Unix(), hash, a.EntryId, a.Mime, dx.sealString(a.Sha256), path, mtime)
+			if err == nil {
+				a.Id, err = res.LastInsertId()
+			}
+		}
+
 		return
+	})
+}
+
+// sniffContent reads r to detect the MIME type and compute the SHA-256 and the
+// blob hash (see blobHash), then rewinds it.
+func sniffContent(d *Diary, r io.ReadSeeker) (mime string, sum string, hash string, err error) {
+	var head = make([]byte, 512)
+	var h = sha256.New()
+	var hb = d.newBlobHash()
+	var w = io.MultiWriter(h, hb)
+
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	if err == nil {
+		mime = http.DetectContentType(head[:n])
+		w.Write(head[:n])
+		_, err = io.Copy(w, r)
+	}
+
+	if err == nil {
+		sum = hex.EncodeToString(h.Sum(nil))
+		hash = hex.EncodeToString(hb.Sum(nil))
+		_, err = r.Seek(0, io.SeekStart)
 	}
 
 	return
 }
 
-func (a *Attachment) Insert(db *sql.DB) (err error) {
-	a.Inserted = time.Now()
+func RetrieveAttachmentByIDNC(d *Diary, id int64) (a Attachment, err error) {
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.id = ?", id)
+
+	if err == nil {
+		defer rows.Close()
 
-	if args.Verbose {
-		logger.info.Printf("%v", a)
+		if rows.Next() {
+			a, err = CreateAttachmentByScanNC(d, rows)
+		} else {
+			err = NOT_FOUND
+		}
+	}
+
+	return
+}
+
+func DeleteAttachment(d *Diary, id int64) (aff int64, err error) {
+	res, err := d.db.Exec("UPDATE attachments set deleted = 1, deleted_at = ? where id = ?", time.Now().Unix(), id)
+	if err == nil {
+		aff, err = res.RowsAffected()
 	}
 
-	_, err = db.Exec("insert into attachments (name, inserted, content, entry_id) values (?, ?, ?, ?)", a.Name, a.Inserted.Unix(), a.Content, a.EntryId)
 	return
 }
 
-func DeleteAttachment(db *sql.DB, id int64) (aff int64, err error) {
-	res, err := db.Exec("UPDATE entries set deleted = 1 where id = ?", id)
+func RestoreAttachment(d *Diary, id int64) (aff int64, err error) {
+	res, err := d.db.Exec("UPDATE attachments set deleted = 0, deleted_at = NULL where id = ? and deleted = 1", id)
 	if err == nil {
 		aff, err = res.RowsAffected()
 	}
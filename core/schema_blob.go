@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// blobHash identifies a content: SHA-256, keyed with the data key (HMAC) on
+// encrypted diaries not to disclose what is stored.
+func (d *Diary) blobHash(content []byte) string {
+	h := d.newBlobHash()
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *Diary) newBlobHash() hash.Hash {
+	if d.key == nil {
+		return sha256.New()
+	}
+
+	return hmac.New(sha256.New, d.key)
+}
+
+// CHUNK_SIZE is the plain size of the chunks contents are stored in: contents
+// are written and read one chunk at a time, never as a whole.
+const CHUNK_SIZE = 1 << 20
+
+// storeBlob stores content, unless it is already stored, and returns its hash.
+// inserted is false if content was already stored.
+func storeBlob(d *Diary, x execer, content []byte) (hash string, inserted bool, err error) {
+	hash = d.blobHash(content)
+	inserted, err = storeBlobFrom(d, x, hash, bytes.NewReader(content))
+	return
+}
+
+// storeBlobFrom stores the content read from r, whose blob hash is hash,
+// unless it is already stored: then r is not read at all.
+func storeBlobFrom(d *Diary, x execer, hash string, r io.Reader) (inserted bool, err error) {
+	var size int64
+
+	res, err := x.Exec("insert or ignore into blobs (hash, size, codec) values (?, 0, '')", hash)
+	if err == nil {
+		var aff int64
+
+		aff, err = res.RowsAffected()
+		inserted = aff > 0
+	}
+
+	if err == nil && inserted {
+		size, err = writeChunks(d, x, hash, r)
+	}
+
+	if err == nil && inserted {
+		_, err = x.Exec("update blobs set size = ? where hash = ?", size, hash)
+	}
+
+	return
+}
+
+// writeChunks stores what is read from r as the chunks of blob hash, see
+// CHUNK_SIZE. size is the plain size of the content.
+func writeChunks(d *Diary, x execer, hash string, r io.Reader) (size int64, err error) {
+	var buf = make([]byte, CHUNK_SIZE)
+
+	for seq := 0; err == nil; seq++ {
+		var n int
+		var data []byte
+		var codec string
+
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF {
+			return size, nil
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+
+		if err == nil {
+			size += int64(n)
+			data, codec, err = d.packContent(buf[:n])
+		}
+
+		if err == nil {
+			_, err = x.Exec("insert into blob_chunks (hash, seq, codec, data) values (?, ?, ?, ?)", hash, seq, codec, data)
+		}
+	}
+
+	return
+}
+
+// writeBlobTo writes the content of blob hash to w, one chunk at a time.
+func writeBlobTo(d *Diary, w io.Writer, hash string) (n int64, err error) {
+	rows, err := d.db.Query("select codec, data from blob_chunks where hash = ? order by seq", hash)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() && err == nil {
+		var codec string
+		var data []byte
+		var m int
+
+		err = rows.Scan(&codec, &data)
+		if err == nil {
+			data, err = d.unpackContent(data, codec)
+		}
+
+		if err == nil {
+			m, err = w.Write(data)
+			n += int64(m)
+		}
+	}
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+// deleteOrphanBlobs deletes blobs no attachment refers to, and their chunks.
+func deleteOrphanBlobs(x execer) (aff int64, err error) {
+	res, err := x.Exec("delete from blobs where hash not in (select hash from attachments where hash is not null)")
+	if err == nil {
+		aff, err = res.RowsAffected()
+	}
+
+	if err == nil {
+		_, err = x.Exec("delete from blob_chunks where hash not in (select hash from blobs)")
+	}
+
+	return
+}
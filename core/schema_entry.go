@@ -5,12 +5,13 @@ package diary
 import (
 	"database/sql"
 	"fmt"
+	"html/template"
 	"os"
 	"strings"
 	"time"
 )
 
-const QUERY_ENTRY_ALL = "select id, init, fin, inserted, note, deleted from entries"
+const QUERY_ENTRY_ALL = "select id, init, fin, inserted, note, deleted, deleted_at, zone from entries"
 
 type Entry struct {
 	Id int64
@@ -19,35 +20,54 @@ type Entry struct {
 	End      time.Time
 	Inserted time.Time
 
-	Note    string
-	Deleted bool
+	Note      string
+	Deleted   bool
+	DeletedAt time.Time // zero unless Deleted
+
+	Tags []string
 }
 
-func CreateEntryByScan(rows *sql.Rows) (e Entry, err error) {
+func CreateEntryByScan(d *Diary, rows *sql.Rows) (e Entry, err error) {
 	var initIn int64
 	var endIn int64
 	var insertedIn int64
 	var deleted int64
+	var noteIn []byte
+	var deletedAt sql.NullInt64
+	var zoneIn sql.NullString
+
+	err = rows.Scan(&e.Id, &initIn, &endIn, &insertedIn, &noteIn, &deleted, &deletedAt, &zoneIn)
+	if err != nil {
+		return
+	}
 
-	err = rows.Scan(&e.Id, &initIn, &endIn, &insertedIn, &e.Note, &deleted)
+	e.Note, err = d.unsealString(noteIn)
 	if err != nil {
 		return
 	}
 
-	e.Init = time.Unix(initIn, 0)
-	e.End = time.Unix(endIn, 0)
+	// in the zone they were written in
+	loc := parseZone(zoneIn.String)
+	e.Init = time.Unix(initIn, 0).In(loc)
+	e.End = time.Unix(endIn, 0).In(loc)
 	e.Inserted = time.Unix(insertedIn, 0)
 	e.Deleted = deleted != 0
 
+	if deletedAt.Valid {
+		e.DeletedAt = time.Unix(deletedAt.Int64, 0)
+	}
+
 	return
 }
 
-func RetrieveEntryByID(db *sql.DB, id int64) (e Entry, err error) {
-	rows, err := db.Query(QUERY_ENTRY_ALL+" where id = ?", id)
+func RetrieveEntryByID(d *Diary, id int64) (e Entry, err error) {
+	rows, err := d.db.Query(QUERY_ENTRY_ALL+" where id = ?", id)
 
 	if err == nil {
+		defer rows.Close()
+
 		if rows.Next() {
-			e, err = CreateEntryByScan(rows)
+			e, err = CreateEntryByScan(d, rows)
 		} else {
 			err = NOT_FOUND
 		}
@@ -56,10 +76,12 @@ func RetrieveEntryByID(db *sql.DB, id int64) (e Entry, err error) {
 	return
 }
 
-func (e *Entry) Insert(db *sql.DB) (err error) {
-	e.Inserted = time.Now()
+func (e *Entry) Insert(d *Diary) (err error) {
+	if e.Inserted.IsZero() {
+		e.Inserted = time.Now()
+	}
 
-	res, err := db.Exec("insert into entries (init, fin, inserted, note, deleted) values (?, ?, ?, ?, 0)", e.Init.Unix(), e.End.Unix(), e.Inserted.Unix(), e.Note)
+	res, err := d.db.Exec("insert into entries (init, fin, inserted, note, deleted, zone) values (?, ?, ?, ?, 0, ?)", e.Init.Unix(), e.End.Unix(), e.Inserted.Unix(), d.sealString(e.Note), zoneName(e.Init))
 	if err != nil {
 		return
 	}
@@ -74,93 +96,168 @@ func (e *Entry) Insert(db *sql.DB) (err error) {
 	return
 }
 
-func (e *Entry) FPrintDumpDay(fp *os.File, db *sql.DB) (err error) {
-	var attachmentCount int
+func (e *Entry) Update(d *Diary) (err error) {
+	res, err := d.db.Exec("update entries set init = ?, fin = ?, note = ?, zone = ? where id = ?", e.Init.Unix(), e.End.Unix(), d.sealString(e.Note), zoneName(e.Init), e.Id)
+	if err != nil {
+		return
+	}
+
+	aff, err := res.RowsAffected()
+	if err == nil && aff == 0 {
+		err = NOT_FOUND
+	}
+
+	return
+}
+
+func DeleteEntry(d *Diary, id int64) (aff int64, err error) {
+	res, err := d.db.Exec("UPDATE entries set deleted = 1, deleted_at = ? where id = ?", time.Now().Unix(), id)
+	if err == nil {
+		aff, err = res.RowsAffected()
+	}
+
+	return
+}
+
+func RestoreEntry(d *Diary, id int64) (aff int64, err error) {
+	res, err := d.db.Exec("UPDATE entries set deleted = 0, deleted_at = NULL where id = ? and deleted = 1", id)
+	if err == nil {
+		aff, err = res.RowsAffected()
+	}
+
+	return
+}
+
+// DumpDay prepares the entry for the dump-day template, making its
+// attachments available with dumper. If dumper is nil, attachments are only
+// listed.
+func (e *Entry) DumpDay(d *Diary, dumper AttachmentDumper) (de DumpEntry, err error) {
+	d.logf("Entry #%d\n", e.Id)
 
-	logger.info.Printf("Entry #%d\n", e.Id)
+	render, ok := noteRenderers[d.Format]
+	if !ok {
+		err = fmt.Errorf("invalid format: %s", d.Format)
+		return
+	}
 
-	fmt.Fprintf(fp, `<p>
-			<span class="record-id">#%d</span>
-			<span class="time">From %s to %s</span><br>
-		`, e.Id, e.Init.Format(time.DateTime), e.End.Format(time.DateTime))
+	err = e.RetrieveTags(d)
+	if err != nil {
+		return
+	}
 
-	noteHtml := strings.Replace(e.Note, "\n", "<br>", -1)
-	fmt.Fprintf(fp, "%s", noteHtml)
+	de = DumpEntry{
+		Id:   e.Id,
+		Init: d.formatTime(e.Init),
+		End:  d.formatTime(e.End),
+		Tags: e.Tags,
+		Note: template.HTML(render(e.Note)),
+	}
 
-	rows, err := db.Query(QUERY_ATTACHMENT_NC+" where entry_id = ? order by inserted", e.Id)
+	rows, err := d.db.Query(QUERY_ATTACHMENT_NC+" where a.entry_id = ? and a.deleted = 0 order by a.inserted", e.Id)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
-	for attachmentCount = 0; rows.Next(); attachmentCount++ {
+	for rows.Next() {
 		var attachment Attachment
-		attachment, err = CreateAttachmentByScanNC(db, rows)
-		if err != nil {
-			return
-		}
 
-		err = attachment.RetrieveContent(db)
+		attachment, err = CreateAttachmentByScanNC(d, rows)
 		if err != nil {
 			return
 		}
 
-		logger.info.Printf("Attachment #%d\n", attachment.Id)
+		d.logf("Attachment #%d\n", attachment.Id)
 
-		if attachmentCount == 0 {
-			fmt.Fprintln(fp, "<table><tr><th>#</th><th>Size</th><th>Name</th></tr>")
+		var modified string
+		if !attachment.Modified.IsZero() {
+			modified = attachment.Modified.Format(time.DateTime)
 		}
 
-		var afp *os.File
-		afp, err = os.OpenFile(attachment.Name, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, os.FileMode(args.OutputPerm))
-		if err != nil {
-			return
+		var da = DumpAttachment{
+			Id:       attachment.Id,
+			Size:     sizeNorm(attachment.Size),
+			Mime:     attachment.Mime,
+			Sha256:   attachment.Sha256,
+			Path:     attachment.Path,
+			Modified: modified,
+			Name:     attachment.Name,
 		}
 
-		afp.Write(attachment.Content)
-		afp.Close()
-
-		fmt.Fprintf(fp, "<tr><td>%d</td><td>%s</td><td><a href=\"%s\" target=\"_blank\">%s</a></td></tr>", attachment.Id, sizeNorm(len(attachment.Content)), attachment.Name, attachment.Name)
-	}
+		if dumper != nil {
+			err = dumper(d, attachment, &da)
+			if err != nil {
+				return
+			}
+		}
 
-	if attachmentCount > 0 {
-		fmt.Fprintln(fp, "</table>")
+		de.Attachments = append(de.Attachments, da)
 	}
 
-	fmt.Fprintln(fp, "</p><hr>")
-
 	return
 }
 
-func (e *Entry) FPrintResume(db *sql.DB, fp *os.File) (n int, err error) {
+func (e *Entry) FPrintResume(d *Diary, fp *os.File) (n int, err error) {
 	var attachmentCount int
 
-	n, _ = fmt.Fprintf(fp, "[%d] %s --> %s\n", e.Id, e.Init.Format(time.DateTime), e.End.Format(time.DateTime))
+	n, _ = fmt.Fprintf(fp, "[%d] %s --> %s\n", e.Id, d.formatTime(e.Init), d.formatTime(e.End))
 	printLine(n, '-', fp)
+
+	if d != nil {
+		err = e.RetrieveTags(d)
+		if err != nil {
+			return
+		}
+
+		if len(e.Tags) > 0 {
+			fmt.Fprintf(fp, "Tags: %s\n", strings.Join(e.Tags, ", "))
+			printLine(n, '-', fp)
+		}
+	}
+
 	fmt.Fprintf(fp, "%s\n", e.Note)
 
-	if db == nil {
+	if d == nil {
 		return
 	}
 
-	rows, err := db.Query("select id, name, length(content) from attachments where entry_id = ? order by inserted", e.Id)
+	rows, err := d.db.Query("select a.id, a.name, "+ATTACHMENT_SIZE+", a.mime, a.sha256, a.path, a.mtime from "+ATTACHMENT_FROM+" where a.entry_id = ? and a.deleted = 0 order by a.inserted", e.Id)
 	if err != nil {
 		return
 	}
 
 	for attachmentCount = 0; rows.Next() && err == nil; attachmentCount++ {
-		var attIdIn int64
-		var nameIn string
+		var a Attachment
+		var meta attachmentMeta
 		var lengthIn int64
 
-		err = rows.Scan(&attIdIn, &nameIn, &lengthIn)
+		err = rows.Scan(&a.Id, &a.Name, &lengthIn, &meta.mime, &meta.sha256, &meta.path, &meta.mtime)
+		if err == nil {
+			err = meta.apply(d, &a)
+		}
 
 		if attachmentCount == 0 {
 			printLine(n, '-', fp)
 			fmt.Fprintln(fp, "Attachments:")
 		}
 
-		fmt.Fprintf(fp, "[%d] %s (%s)\n", attIdIn, nameIn, sizeNorm(lengthIn))
+		fmt.Fprintf(fp, "[%d] %s (%s", a.Id, a.Name, sizeNorm(lengthIn))
+		if a.Mime != "" {
+			fmt.Fprintf(fp, ", %s", a.Mime)
+		}
+		fmt.Fprintln(fp, ")")
+
+		if a.Path != "" {
+			fmt.Fprintf(fp, "    from %s", a.Path)
+			if !a.Modified.IsZero() {
+				fmt.Fprintf(fp, ", modified %s", a.Modified.Format(time.DateTime))
+			}
+			fmt.Fprintln(fp)
+		}
+
+		if a.Sha256 != "" {
+			fmt.Fprintf(fp, "    sha256 %s\n", a.Sha256)
+		}
 	}
 
 	rows.Close()
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// tagList collects the values of a repeatable flag.
+type tagList []string
+
+func (t *tagList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagList) Set(value string) error {
+	value = normTag(value)
+	if value == "" {
+		return errors.New("empty tag")
+	}
+
+	*t = append(*t, value)
+	return nil
+}
+
+func normTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// tagFilter returns a clause to be appended to a query on entries so that
+// only entries having at least one of the given tags are selected.
+// If no tag is given the clause is empty.
+func tagFilter(tags []string) (clause string, params []any) {
+	if len(tags) == 0 {
+		return
+	}
+
+	clause = " and id in (select et.entry_id from entry_tags et join tags t on t.id = et.tag_id where t.name in (?" + strings.Repeat(", ?", len(tags)-1) + "))"
+
+	for _, tx := range tags {
+		params = append(params, tx)
+	}
+
+	return
+}
+
+func (e *Entry) AddTags(d *Diary, tags []string) (err error) {
+	for _, tx := range tags {
+		_, err = d.db.Exec("insert or ignore into tags (name) values (?)", tx)
+
+		if err == nil {
+			_, err = d.db.Exec("insert or ignore into entry_tags (entry_id, tag_id) select ?, id from tags where name = ?", e.Id, tx)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return e.RetrieveTags(d)
+}
+
+func (e *Entry) RemoveTags(d *Diary, tags []string) (aff int64, err error) {
+	for _, tx := range tags {
+		var res sql.Result
+		var n int64
+
+		res, err = d.db.Exec("delete from entry_tags where entry_id = ? and tag_id in (select id from tags where name = ?)", e.Id, tx)
+		if err == nil {
+			n, err = res.RowsAffected()
+			aff += n
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	err = e.RetrieveTags(d)
+	return
+}
+
+func (e *Entry) RetrieveTags(d *Diary) (err error) {
+	rows, err := d.db.Query("select t.name from entry_tags et join tags t on t.id = et.tag_id where et.entry_id = ? order by t.name", e.Id)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	e.Tags = e.Tags[:0]
+	for rows.Next() && err == nil {
+		var name string
+
+		err = rows.Scan(&name)
+		if err == nil {
+			e.Tags = append(e.Tags, name)
+		}
+	}
+
+	return
+}
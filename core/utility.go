@@ -3,13 +3,16 @@
 package diary
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand/v2"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -25,18 +28,48 @@ type arguments struct {
 	Help    bool
 	Verbose bool
 	Force   bool
+	Merge   bool
+	DryRun  bool
+	Mtime   bool
+	Week    bool
+	Month   bool
+	Check   bool
+	Fix     bool
 
 	Id         int64
+	Addr       string
+	Editor     string
 	DateInit   time.Time
 	DateEnd    time.Time
 	Note       string
+	Attachment bool
+	Retention  int
+	Keep       int
+	Query      string
+	Last       string
+	Format     string
+	Codec      string
+	Tags       tagList
 	NoAttach   bool
 	OutputFile *os.File
 	OutputPerm int
+	Zone       *time.Location
+
+	// flags explicitly set by the user
+	Set map[string]bool
+
+	// help of a command, or the list of commands; HELP_PAGE if empty
+	Usage string
 
 	// unchecked input
 	OutputFileStr string
+	InputFileStr  string
+	TemplateDir   string
+	CertFile      string
+	KeyFile       string
+	ZoneStr       string
 	OutputPermStr string
+	WorkDir       string
 	DateInitStr   string
 	DateEndStr    string
 	TimeInitStr   string
@@ -79,7 +112,7 @@ func readAllFileContent(path string) (text string, err error) {
 	var n = -1
 
 	for n, err = fp.Read(buf); err == nil && n != 0; n, err = fp.Read(buf) {
-		sb.Write(buf)
+		sb.Write(buf[:n])
 	}
 
 	if err.Error() == "EOF" {
@@ -90,19 +123,33 @@ func readAllFileContent(path string) (text string, err error) {
 	return
 }
 
-func editor() (text string, err error) {
+func editor(initial string) (text string, err error) {
 	fileName := "diary_" + getRandomString()
-	cmd := exec.Command("vim", fileName)
+
+	if initial != "" {
+		err = os.WriteFile(fileName, []byte(initial), 0600)
+		if err != nil {
+			return
+		}
+	}
+
+	// e.g. "code --wait"
+	command := strings.Fields(args.Editor)
+	if len(command) == 0 {
+		return "", errors.New("no editor")
+	}
+
+	cmd := exec.Command(command[0], append(command[1:], fileName)...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 
 	err = cmd.Run()
 	if err == nil {
 		text, err = readAllFileContent(fileName)
-		err1 := os.Remove(fileName)
-		if err1 != nil {
-			logger.err.Printf("could not remove temp file: %s", fileName)
-		}
+	}
+
+	if err1 := os.Remove(fileName); err1 != nil && !os.IsNotExist(err1) {
+		logger.err.Printf("could not remove temp file: %s", fileName)
 	}
 
 	return
@@ -166,80 +213,211 @@ func myerr(err error, fatal bool) {
 	}
 }
 
+// confirm asks the user a yes/no question, the default answer is no.
+func confirm(question string) bool {
+	var k = bufio.NewScanner(os.Stdin)
+
+	fmt.Printf("%s [y/N]: ", question)
+	k.Scan()
+
+	answer := strings.ToLower(strings.TrimSpace(k.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// parseArgs parses the command line, then reads the configuration: a broken
+// configuration does not prevent help from being shown.
 func parseArgs() (err error) {
-	var wd string
+	defaultArgs()
+
+	// diary COMMAND [flags] [ARGS], or the older diary -cmd COMMAND [flags]
+	if argv := os.Args[1:]; len(argv) == 0 {
+		err = parseHelp(nil)
+	} else if !strings.HasPrefix(argv[0], "-") {
+		err = parseCommand(argv)
+	} else {
+		err = parseLegacyArgs(argv)
+	}
+
+	if err != nil || args.Help {
+		return
+	}
+
+	conf, confPath, err := loadConfig()
+	if err != nil {
+		return
+	}
+
+	if confPath != "" && args.Verbose {
+		logger.info.Printf("Configuration: %s\n", confPath)
+	}
 
+	err = applyConfig(conf)
+	if err == nil && args.Path == "" {
+		err = errors.New("no diary path: use -path, DIARY_PATH or the config file")
+	}
+	if err != nil {
+		return
+	}
+
+	return checkArgs()
+}
+
+// defaultArgs sets the defaults of flags: a flag set defines its flags with
+// the current values of args as defaults. Those of the configuration are
+// applied later, see applyConfig.
+func defaultArgs() {
+	args.Path = os.Getenv("DIARY_PATH")
+	args.Editor = firstNonEmpty(os.Getenv("VISUAL"), os.Getenv("EDITOR"))
+	args.Id = -1
+	args.Addr = "127.0.0.1:8080"
+	args.Codec = CODEC_FLATE
+	args.Format = "markdown"
+	args.Retention = 30
+	args.OutputPermStr = "660"
+	args.ZoneStr = os.Getenv("DIARY_ZONE")
+}
+
+// applyConfig gives the values of conf to what neither flags nor the
+// environment set.
+func applyConfig(conf config) (err error) {
+	args.Path = firstNonEmpty(args.Path, expandHome(conf.Path))
+	args.Editor = firstNonEmpty(args.Editor, conf.Editor, "vim")
+	args.ZoneStr = firstNonEmpty(args.ZoneStr, conf.Zone)
+
+	if !args.Set["operm"] && conf.Operm != "" {
+		args.OutputPermStr = conf.Operm
+	}
+
+	// default tags are given to new entries
+	if !args.Set["tag"] && args.Command == "add" {
+		for ix := 0; ix < len(conf.Tags) && err == nil; ix++ {
+			err = args.Tags.Set(conf.Tags[ix])
+		}
+	}
+
+	return
+}
+
+// parseLegacyArgs parses the single flag set of older versions, where the
+// command is given by -cmd and every flag is accepted by every command.
+func parseLegacyArgs(argv []string) (err error) {
 	f := flag.NewFlagSet("usage", flag.ContinueOnError)
 
-	f.StringVar(&args.Path, "path", "", "diary file path")
-	f.StringVar(&args.Command, "cmd", "", "command (add, resume, delete, fetch, dump-day, dump, license)")
-	f.StringVar(&args.Note, "note", "", "note to log into the diary")
-	f.Int64Var(&args.Id, "id", -1, "entry id")
+	flagCommon(f)
+	flags(flagNote, flagDates, flagTags, flagNoAttach, flagCodec, flagFormat, flagTemplates,
+		flagOperm, flagMtime, flagForce, flagDryRun, flagAttachment, flagRetention, flagMerge, flagAddr,
+		flagTLS, flagZone, flagSpans, flagBackup, flagFix)(f)
+
+	f.StringVar(&args.Command, "cmd", "", "command (see diary help)")
+	f.StringVar(&args.Query, "q", "", "full-text search query")
+	f.Int64Var(&args.Id, "id", args.Id, "entry id")
 	f.BoolVar(&args.Help, "help", false, "show this menu")
-	f.BoolVar(&args.NoAttach, "na", false, "tells the program not to ask for attachments")
-	f.StringVar(&args.DateInitStr, "di", time.Now().Format(time.DateOnly), "init date for requested operation")
-	f.StringVar(&args.DateEndStr, "de", "", "end date for requested operation, if empty it's set equal tu date-init")
-	f.StringVar(&args.TimeInitStr, "ti", time.Now().Format(time.TimeOnly), "init time for requested operation")
-	f.StringVar(&args.TimeEndStr, "te", "", "end time for requested operation, if empty it's set equal tu time-init")
 	f.StringVar(&args.OutputFileStr, "output", "", "output file path (default: stdout)")
-	f.StringVar(&args.OutputPermStr, "operm", "660", "output file path permission")
-	f.StringVar(&wd, "wd", "", "working directory")
-	f.BoolVar(&args.Verbose, "v", false, "verbose info")
-	f.BoolVar(&args.Force, "f", false, "force")
-
-	out := f.Output()
-	f.SetOutput(stdnull)
-	defer func() {
-		f.SetOutput(out)
-	}()
-
-	err = f.Parse(os.Args[1:])
+	f.StringVar(&args.InputFileStr, "input", "", "input file path")
+
+	f.SetOutput(io.Discard)
+
+	err = f.Parse(argv)
+	if err == flag.ErrHelp {
+		args.Help = true
+		return nil
+	}
 	if err != nil {
-		if err == flag.ErrHelp {
-			err = nil
-			args.Help = true
-			return
-		}
 		return
 	}
 
-	if args.Command == "help" {
+	visitSet(f)
+
+	args.Command = strings.ToLower(args.Command)
+
+	switch {
+	case args.Command == "help":
 		args.Help = true
-		return
+	case args.Command == "" && !args.Help:
+		err = errors.New("missing command (see diary help)")
+	case args.Command != "":
+		if _, ok := lookupCommand(args.Command); !ok {
+			err = fmt.Errorf("invalid command: %s", args.Command)
+		}
 	}
 
+	return
+}
+
+// visitSet records the flags explicitly set by the user.
+func visitSet(f *flag.FlagSet) {
+	args.Set = make(map[string]bool)
+	f.Visit(func(fx *flag.Flag) {
+		args.Set[fx.Name] = true
+	})
+}
+
+// checkArgs validates and converts what has been parsed, whatever the form.
+func checkArgs() (err error) {
 	if args.Force {
 		logger.warn.Println("Using -f")
 	}
 
-	if wd != "" {
-		err = os.Chdir(wd)
+	if args.WorkDir != "" {
+		err = os.Chdir(args.WorkDir)
 	}
 	if err != nil {
 		return
 	}
 
-	args.DateInit, err = time.ParseInLocation(time.DateTime, args.DateInitStr+" "+args.TimeInitStr, time.Now().Location())
+	now := time.Now()
+
+	args.DateInit, err = parseDateTime(args.DateInitStr, args.TimeInitStr, now, now)
 	if err != nil {
 		return fmt.Errorf("datetime init: %s", err.Error())
 	}
 
+	// the end defaults to the init: same day, same time
 	if args.DateEndStr == "" {
-		args.DateEndStr = args.DateInitStr
-	}
-	if args.TimeEndStr == "" {
-		args.TimeEndStr = args.TimeInitStr
+		args.DateEnd, err = setClock(args.DateInit, args.TimeEndStr, now, args.DateInit)
+	} else {
+		args.DateEnd, err = parseDateTime(args.DateEndStr, args.TimeEndStr, now, args.DateInit)
 	}
-	args.DateEnd, err = time.ParseInLocation(time.DateTime, args.DateEndStr+" "+args.TimeEndStr, time.Now().Location())
 	if err != nil {
 		return fmt.Errorf("datetime end: %s", err.Error())
 	}
 
+	if args.DateEnd.Before(args.DateInit) {
+		return errors.New("datetime end comes before datetime init")
+	}
+
+	// days are bucketed in it, entries are still written in the local zone
+	args.Zone = time.Local
+	if args.ZoneStr != "" {
+		args.Zone, err = time.LoadLocation(args.ZoneStr)
+		if err != nil {
+			return fmt.Errorf("zone: %s", err.Error())
+		}
+	}
+
+	args.Format = strings.ToLower(args.Format)
+	if _, ok := noteRenderers[args.Format]; !ok {
+		return fmt.Errorf("invalid format: %s", args.Format)
+	}
+
+	args.Codec = strings.ToLower(args.Codec)
+	if args.Codec == "none" {
+		args.Codec = CODEC_NONE
+	}
+	if !validCodec(args.Codec) {
+		return fmt.Errorf("invalid codec: %s", args.Codec)
+	}
+
 	args.OutputPerm, err = permStrToInt(args.OutputPermStr)
 	if err != nil {
 		return
 	}
 
+	// commands like backup write it themselves, when done
+	if cmd, _ := lookupCommand(args.Command); cmd.ownOutput {
+		return
+	}
+
 	switch args.OutputFileStr {
 	case "-":
 		args.OutputFile = os.Stdout
@@ -252,70 +430,29 @@ func parseArgs() (err error) {
 	return
 }
 
-func (a arguments) Clear() {
-	if a.OutputFile != nil && a.OutputFile != os.Stdout {
-		a.OutputFile.Close()
-	}
-}
-
-func rmR(path string, onlyChildren bool) (err error) {
-	var ddee []os.DirEntry
-	var stat os.FileInfo
-
-	stat, err = os.Stat(path)
-
-	if os.IsNotExist(err) {
-		err = nil
-		return
-	}
-
-	if err == nil && stat.IsDir() {
-
-		ddee, err = os.ReadDir(path)
-		if err == nil {
-			for _, dex := range ddee {
-				err = rmR(path+"/"+dex.Name(), false)
-
-				if err != nil {
-					break
-				}
-			}
-		}
-	}
-
-	if err == nil && !onlyChildren {
-		if stat.IsDir() {
-			logger.info.Printf("Deleting directory \"%s\"", path)
-		} else {
-			logger.info.Printf("Deleting regular file \"%s\"", path)
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
-
-		err = os.Remove(path)
 	}
 
-	return
+	return ""
 }
 
-func createDirectoryIfNE(dir string) error {
-	var ddee []os.DirEntry
-
-	stat, err := os.Stat(dir)
-	if err == nil {
-		if !stat.IsDir() {
-			err = errors.New(dir + " is not a directory")
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(path string) string {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
 		}
+	}
 
-		if err == nil {
-			ddee, err = os.ReadDir(dir)
+	return path
+}
 
-			if len(ddee) > 0 {
-				err = errors.New(dir + " not empty")
-			}
-		}
-	} else if os.IsNotExist(err) {
-		err = os.Mkdir(dir, os.FileMode(args.OutputPerm|0100))
-		logger.info.Printf("created directory %s", dir)
+func (a arguments) Clear() {
+	if a.OutputFile != nil && a.OutputFile != os.Stdout {
+		a.OutputFile.Close()
 	}
-
-	return err
 }
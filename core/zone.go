@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MIT
+
+package diary
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	_ "time/tzdata" // zones of entries written elsewhere, if missing here
+)
+
+// Entries keep the zone they were written in, so that their wall-clock time
+// does not change when the diary is read elsewhere. Days are bucketed in
+// Diary.Zone.
+
+// zoneName names the location of t: its IANA name if known, otherwise its
+// offset from UTC, as +02:00.
+func zoneName(t time.Time) string {
+	var name = t.Location().String()
+
+	if t.Location() == time.Local {
+		name = localZoneName()
+	}
+
+	if name == "" || name == "Local" {
+		name = t.Format("-07:00")
+	}
+
+	return name
+}
+
+// localZoneName is the IANA name of time.Local, if it can be found: time
+// names it Local when it comes from $TZ or /etc/localtime.
+func localZoneName() (name string) {
+	name = time.Local.String()
+	if name != "Local" {
+		return
+	}
+
+	name = strings.TrimPrefix(os.Getenv("TZ"), ":")
+	if name == "" {
+		if link, err := os.Readlink("/etc/localtime"); err == nil {
+			if _, after, found := strings.Cut(link, "zoneinfo/"); found {
+				name = after
+			}
+		}
+	}
+
+	// the name must describe the same zone
+	if loc, err := time.LoadLocation(name); err != nil || !sameZone(loc, time.Local) {
+		name = ""
+	}
+
+	return
+}
+
+// sameZone compares the offsets of a and b now and in six months, which is
+// enough to tell daylight saving time apart.
+func sameZone(a *time.Location, b *time.Location) bool {
+	var now = time.Now()
+
+	for _, tx := range []time.Time{now, now.AddDate(0, 6, 0)} {
+		_, offA := tx.In(a).Zone()
+		_, offB := tx.In(b).Zone()
+
+		if offA != offB {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseZone returns the location named by zoneName. Empty names (older
+// entries) and unknown ones are the local zone.
+func parseZone(name string) (loc *time.Location) {
+	if name == "" {
+		return time.Local
+	}
+
+	if t, err := time.Parse("-07:00", name); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.Local
+	}
+
+	return
+}
+
+// formatTime formats t in its own zone, adding the offset if it differs
+// from the one of d.Zone (of the local zone, if d is nil).
+func (d *Diary) formatTime(t time.Time) (s string) {
+	var zone = time.Local
+
+	if d != nil {
+		zone = d.Zone
+	}
+
+	s = t.Format(time.DateTime)
+
+	_, offset := t.Zone()
+	_, offsetZ := t.In(zone).Zone()
+
+	if offset != offsetZ {
+		s += " " + t.Format("-07:00")
+	}
+
+	return
+}
+
+// dayStart is the midnight of the day of date, taken as a calendar date, in
+// d.Zone.
+func (d *Diary) dayStart(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, d.Zone)
+}